@@ -0,0 +1,282 @@
+package prox
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// BreakerState is the state of a provider's circuit breaker.
+type BreakerState int
+
+const (
+	// BreakerClosed means the provider is fetched as normal.
+	BreakerClosed BreakerState = iota
+
+	// BreakerOpen means the provider has failed too many times in a row and is skipped until the cooldown
+	// has passed.
+	BreakerOpen
+
+	// BreakerHalfOpen means the cooldown has passed and the next fetch is a probe. If it succeeds the breaker
+	// closes, otherwise it opens again.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// CircuitBreaker stops a provider from being fetched after a number of consecutive failed fetches.
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+}
+
+// NewCircuitBreaker creates a circuit breaker which opens after threshold consecutive failures and stays open
+// for the cooldown given.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{Threshold: threshold, Cooldown: cooldown}
+}
+
+// Allow reports whether the provider should be fetched. Once the cooldown of an open breaker has passed, Allow
+// moves it to half-open and returns true once, so that a single probe can be made.
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.Cooldown {
+			return false
+		}
+
+		b.state = BreakerHalfOpen
+		return true
+	case BreakerHalfOpen:
+		// A probe is already in progress.
+		return false
+	default:
+		return true
+	}
+}
+
+// Success records a successful fetch, closing the breaker.
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.state = BreakerClosed
+	b.failures = 0
+}
+
+// Failure records a failed fetch. The breaker opens if the probe of a half-open breaker failed or if the
+// amount of consecutive failures has reached the threshold.
+func (b *CircuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+
+	if b.state == BreakerHalfOpen || (b.Threshold > 0 && b.failures >= b.Threshold) {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// State returns the current state of the breaker. An open breaker whose cooldown has passed is reported as
+// half-open.
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.Cooldown {
+		return BreakerHalfOpen
+	}
+
+	return b.state
+}
+
+// Failures returns the amount of consecutive failed fetches.
+func (b *CircuitBreaker) Failures() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.failures
+}
+
+// RetryAt returns when an open breaker will allow a probe. It is the zero time if the breaker isn't open.
+func (b *CircuitBreaker) RetryAt() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != BreakerOpen {
+		return time.Time{}
+	}
+
+	return b.openedAt.Add(b.Cooldown)
+}
+
+// breakerSnapshot is the on-disk representation of a circuit breaker.
+type breakerSnapshot struct {
+	State    BreakerState `json:"state"`
+	Failures int          `json:"failures"`
+	OpenedAt time.Time    `json:"opened_at"`
+}
+
+// Breakers holds a circuit breaker for each provider, by name. It can be shared between pools, and saved to
+// disk so that failing providers are remembered between runs.
+type Breakers struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu       sync.Mutex
+	breakers map[string]*CircuitBreaker
+}
+
+// NewBreakers creates a new set of circuit breakers. Each provider's breaker opens after threshold consecutive
+// failures and stays open for the cooldown given.
+func NewBreakers(threshold int, cooldown time.Duration) *Breakers {
+	return &Breakers{
+		Threshold: threshold,
+		Cooldown:  cooldown,
+		breakers:  make(map[string]*CircuitBreaker),
+	}
+}
+
+// Get returns the circuit breaker for the provider with the name given, creating it if needed.
+func (b *Breakers) Get(name string) *CircuitBreaker {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	breaker, ok := b.breakers[name]
+	if !ok {
+		breaker = NewCircuitBreaker(b.Threshold, b.Cooldown)
+		b.breakers[name] = breaker
+	}
+
+	return breaker
+}
+
+// Names returns the names of the providers with a breaker, sorted.
+func (b *Breakers) Names() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	names := make([]string, 0, len(b.breakers))
+	for name := range b.breakers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// States returns the state of every breaker, by provider name.
+func (b *Breakers) States() map[string]BreakerState {
+	states := make(map[string]BreakerState)
+	for _, name := range b.Names() {
+		states[name] = b.Get(name).State()
+	}
+
+	return states
+}
+
+// Save writes the state of every breaker to the file at path as JSON.
+func (b *Breakers) Save(path string) error {
+	snapshot := make(map[string]breakerSnapshot)
+
+	for _, name := range b.Names() {
+		breaker := b.Get(name)
+
+		breaker.mu.Lock()
+		snapshot[name] = breakerSnapshot{State: breaker.state, Failures: breaker.failures, OpenedAt: breaker.openedAt}
+		breaker.mu.Unlock()
+	}
+
+	bytes, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "prox: cannot encode circuit breakers")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrap(err, "prox: cannot save circuit breakers")
+	}
+
+	if err := ioutil.WriteFile(path, bytes, 0644); err != nil {
+		return errors.Wrap(err, "prox: cannot save circuit breakers")
+	}
+
+	return nil
+}
+
+// Load reads the state of the breakers from a file written by Save. A missing file is not an error.
+func (b *Breakers) Load(path string) error {
+	bytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "prox: cannot load circuit breakers")
+	}
+
+	snapshot := make(map[string]breakerSnapshot)
+	if err := json.Unmarshal(bytes, &snapshot); err != nil {
+		return errors.Wrap(err, "prox: cannot decode circuit breakers")
+	}
+
+	for name, s := range snapshot {
+		breaker := b.Get(name)
+
+		// A probe can't still be in progress from a previous run, so let it be retried.
+		if s.State == BreakerHalfOpen {
+			s.State = BreakerOpen
+		}
+
+		breaker.mu.Lock()
+		breaker.state = s.State
+		breaker.failures = s.Failures
+		breaker.openedAt = s.OpenedAt
+		breaker.mu.Unlock()
+	}
+
+	return nil
+}
+
+// OptionCircuitBreaker sets the option to skip a provider for the cooldown given once it has failed threshold
+// times in a row. After the cooldown, the provider is fetched once more and only used again if that succeeds.
+func OptionCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return OptionBreakers(NewBreakers(threshold, cooldown))
+}
+
+// OptionBreakers sets the circuit breakers used by the pool. This allows breakers to be shared between pools
+// or loaded from disk.
+func OptionBreakers(breakers *Breakers) Option {
+	return func(pool *ComplexPool) error {
+		pool.breakers = breakers
+		return nil
+	}
+}
+
+// Breakers returns the circuit breakers used by the pool, or nil if the pool doesn't use any.
+func (pool *ComplexPool) Breakers() *Breakers {
+	return pool.breakers
+}
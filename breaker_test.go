@@ -0,0 +1,75 @@
+package prox_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ollybritton/prox"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCircuitBreaker tests that a breaker opens after enough failures, allows a single probe after the
+// cooldown and closes again once the probe succeeds.
+func TestCircuitBreaker(t *testing.T) {
+	breaker := prox.NewCircuitBreaker(2, 10*time.Millisecond)
+
+	assert.True(t, breaker.Allow())
+	breaker.Failure()
+	assert.Equal(t, prox.BreakerClosed, breaker.State(), "breaker should stay closed below the threshold")
+
+	breaker.Failure()
+	assert.Equal(t, prox.BreakerOpen, breaker.State())
+	assert.False(t, breaker.Allow(), "open breaker should not allow fetches")
+
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, prox.BreakerHalfOpen, breaker.State())
+	assert.True(t, breaker.Allow(), "breaker should allow a probe after the cooldown")
+	assert.False(t, breaker.Allow(), "breaker should only allow a single probe")
+
+	breaker.Failure()
+	assert.Equal(t, prox.BreakerOpen, breaker.State(), "failed probe should open the breaker again")
+
+	time.Sleep(20 * time.Millisecond)
+	assert.True(t, breaker.Allow())
+	breaker.Success()
+	assert.Equal(t, prox.BreakerClosed, breaker.State(), "successful probe should close the breaker")
+	assert.Equal(t, 0, breaker.Failures())
+}
+
+// TestComplexPoolCircuitBreaker tests that a pool skips providers whose circuit breaker is open.
+func TestComplexPoolCircuitBreaker(t *testing.T) {
+	pool := prox.NewComplexPool(
+		prox.UseProviders(DummyProviderError, DummyProvider),
+		prox.OptionCircuitBreaker(2, time.Hour),
+	)
+
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, pool.Load())
+	}
+
+	states := pool.Breakers().States()
+	assert.Equal(t, prox.BreakerOpen, states["DummyProviderError"])
+	assert.Equal(t, prox.BreakerClosed, states["DummyProvider"])
+
+	reports := pool.Reports()
+	assert.True(t, reports[0].Skipped, "provider with an open breaker should be skipped")
+	assert.False(t, reports[1].Skipped)
+}
+
+// TestBreakersSaveLoad tests that breakers keep their state when saved to disk.
+func TestBreakersSaveLoad(t *testing.T) {
+	breakers := prox.NewBreakers(1, time.Hour)
+	breakers.Get("Failing").Failure()
+	breakers.Get("Working").Success()
+
+	path := filepath.Join(t.TempDir(), "breakers.json")
+	assert.Nil(t, breakers.Save(path))
+
+	loaded := prox.NewBreakers(1, time.Hour)
+	assert.Nil(t, loaded.Load(path))
+
+	assert.Equal(t, prox.BreakerOpen, loaded.Get("Failing").State())
+	assert.Equal(t, prox.BreakerClosed, loaded.Get("Working").State())
+	assert.Nil(t, loaded.Load(filepath.Join(t.TempDir(), "missing.json")), "a missing file should not cause an error")
+}
@@ -0,0 +1,247 @@
+package prox
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Chain makes connections through several proxies in turn: the first proxy is connected to directly and asked to
+// connect to the second, and so on, with the last proxy connecting to the target. Any mix of HTTP, HTTPS, SOCKS and
+// SSH proxies can be chained. HTTP and HTTPS proxies are always asked to tunnel using CONNECT, so they have to
+// support it. SSH proxies are logged in to again for every connection.
+type Chain struct {
+	proxies []Proxy
+}
+
+// ChainError is the error returned when a connection through a chain fails. It says which proxy in the chain
+// failed, either because it couldn't be reached or because it couldn't connect to the next hop.
+type ChainError struct {
+	// Index is the index of the proxy which failed in the chain, starting from 0.
+	Index int
+
+	// Hops is the amount of proxies in the chain.
+	Hops int
+
+	// Proxy is the URL of the proxy which failed, with any credentials replaced.
+	Proxy string
+
+	Err error
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("prox: hop %d of %d (%v) failed: %v", e.Index+1, e.Hops, e.Proxy, e.Err)
+}
+
+// Cause returns the error which made the hop fail.
+func (e *ChainError) Cause() error {
+	return e.Err
+}
+
+// NewChain creates a chain which goes through the proxies given, in order. It returns an error if there are no
+// proxies, or if any of them has a scheme which can't be chained.
+func NewChain(proxies ...Proxy) (*Chain, error) {
+	if len(proxies) == 0 {
+		return nil, fmt.Errorf("prox: cannot create a chain without any proxies")
+	}
+
+	for i, p := range proxies {
+		switch p.URL.Scheme {
+		case "http", "https", "socks4", "socks4a", "socks5", "socks5h", "ssh":
+		default:
+			return nil, fmt.Errorf("prox: cannot chain %v proxy at hop %d", p.URL.Scheme, i+1)
+		}
+	}
+
+	return &Chain{proxies: proxies}, nil
+}
+
+// Proxies returns the proxies in the chain, in order.
+func (c *Chain) Proxies() []Proxy {
+	return append([]Proxy{}, c.proxies...)
+}
+
+// String returns the URLs of the proxies in the chain separated by arrows, with any credentials replaced.
+func (c *Chain) String() string {
+	s := ""
+	for i, p := range c.proxies {
+		if i != 0 {
+			s += " -> "
+		}
+
+		s += p.Redacted()
+	}
+
+	return s
+}
+
+// Dial connects to addr through every proxy in the chain. See DialContext.
+func (c *Chain) Dial(network, addr string) (net.Conn, error) {
+	return c.DialContext(context.Background(), network, addr)
+}
+
+// DialContext connects to addr through every proxy in the chain. Only TCP connections are supported. If any hop
+// fails, the error returned is a *ChainError saying which. The context only applies to connecting and the
+// handshakes.
+func (c *Chain) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("prox: chains cannot make %v connections", network)
+	}
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", c.proxies[0].URL.Host)
+	if err != nil {
+		return nil, c.hopError(0, err)
+	}
+
+	for i, p := range c.proxies {
+		next := addr
+		if i+1 < len(c.proxies) {
+			next = c.proxies[i+1].URL.Host
+		}
+
+		conn, err = tunnel(ctx, conn, p, next)
+		if err != nil {
+			return nil, c.hopError(i, err)
+		}
+	}
+
+	return conn, nil
+}
+
+// Client returns a http.Client whose requests go through every proxy in the chain.
+func (c *Chain) Client() *http.Client {
+	return &http.Client{Transport: &http.Transport{DialContext: c.DialContext}}
+}
+
+func (c *Chain) hopError(i int, err error) error {
+	return &ChainError{Index: i, Hops: len(c.proxies), Proxy: c.proxies[i].Redacted(), Err: err}
+}
+
+// tunnel asks the proxy p, which conn is connected to, to connect to addr, and returns the connection to addr.
+// conn is closed if it fails.
+func tunnel(ctx context.Context, conn net.Conn, p Proxy, addr string) (net.Conn, error) {
+	var (
+		tunnelled net.Conn
+		err       error
+	)
+
+	u := p.URL
+
+	switch u.Scheme {
+	case "http", "https":
+		tunnelled, err = httpConnect(ctx, conn, u, addr)
+	case "socks4", "socks4a":
+		var dialer *socks4Dialer
+
+		dialer, err = newSOCKS4Dialer(u, &connDialer{conn})
+		if err == nil {
+			tunnelled, err = dialer.DialContext(ctx, "tcp", addr)
+		}
+	case "socks5", "socks5h":
+		var dialer *socks5Dialer
+
+		dialer, err = newSOCKS5Dialer(u, &connDialer{conn})
+		if err == nil {
+			tunnelled, err = dialer.DialContext(ctx, "tcp", addr)
+		}
+	case "ssh":
+		tunnelled, err = sshTunnel(ctx, conn, u, p.sshConfig(), addr)
+	default:
+		err = fmt.Errorf("cannot tunnel through %v proxy", u.Scheme)
+	}
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return tunnelled, nil
+}
+
+// httpConnect sends a CONNECT request for addr to the HTTP proxy at u, which conn is connected to. If the proxy's
+// scheme is https, the connection to the proxy uses TLS. Any credentials in u are sent in the
+// Proxy-Authorization header.
+func httpConnect(ctx context.Context, conn net.Conn, u *url.URL, addr string) (net.Conn, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	if u.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tlsConn.Handshake(); err != nil {
+			return nil, fmt.Errorf("tls handshake with proxy failed: %v", err)
+		}
+
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+
+	if u.User != nil {
+		password, _ := u.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(u.User.Username() + ":" + password))
+
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	if err := req.Write(conn); err != nil {
+		return nil, fmt.Errorf("cannot send CONNECT request: %v", err)
+	}
+
+	r := bufio.NewReader(conn)
+
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read CONNECT response: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("CONNECT request for %v returned status %d", addr, resp.StatusCode)
+	}
+
+	if r.Buffered() != 0 {
+		return &readerConn{conn, r}, nil
+	}
+
+	return conn, nil
+}
+
+// connDialer is a dialer which returns the same connection, already made, for any address. It lets SOCKS
+// dialers do their handshake over a connection tunnelled through other proxies.
+type connDialer struct {
+	conn net.Conn
+}
+
+func (d *connDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.conn, nil
+}
+
+func (d *connDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d.conn, nil
+}
+
+// readerConn is a connection whose reads go through a reader which may have already buffered data from it.
+type readerConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *readerConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
@@ -0,0 +1,108 @@
+package prox_test
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ollybritton/prox"
+	"github.com/stretchr/testify/assert"
+)
+
+// chainFor creates a chain of the proxies with the URLs given, failing the test if it is invalid.
+func chainFor(t *testing.T, rawurls ...string) *prox.Chain {
+	proxies := []prox.Proxy{}
+	for _, rawurl := range rawurls {
+		proxies = append(proxies, proxyFor(t, rawurl))
+	}
+
+	chain, err := prox.NewChain(proxies...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return chain
+}
+
+// closedAddress returns an address nothing is listening on.
+func closedAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := listener.Addr().String()
+	listener.Close()
+
+	return addr
+}
+
+// TestChain tests that requests go through a chain of HTTP and SOCKS proxies.
+func TestChain(t *testing.T) {
+	target := startTarget(t)
+	httpProxy := strings.TrimPrefix(startHTTPProxy(t, "user", "pass").URL, "http://")
+	socks5 := startSOCKS5Proxy(t, "user", "pass")
+	socks4 := startSOCKS4Proxy(t, "alice")
+
+	chains := [][]string{
+		{"http://user:pass@" + httpProxy, "socks5://user:pass@" + socks5},
+		{"socks5://user:pass@" + socks5, "http://user:pass@" + httpProxy, "socks4a://alice@" + socks4},
+		{"socks4://alice@" + socks4, "socks5h://user:pass@" + socks5},
+	}
+
+	for _, urls := range chains {
+		chain := chainFor(t, urls...)
+
+		client := chain.Client()
+		client.Timeout = 5 * time.Second
+
+		resp, err := client.Get(target.URL)
+		if assert.Nil(t, err, chain.String()) {
+			resp.Body.Close()
+			assert.Equal(t, http.StatusNoContent, resp.StatusCode, chain.String())
+		}
+	}
+}
+
+// TestChainErrors tests that errors say which hop of the chain failed, without leaking credentials.
+func TestChainErrors(t *testing.T) {
+	target := startTarget(t)
+	httpProxy := strings.TrimPrefix(startHTTPProxy(t, "user", "s3cret").URL, "http://")
+	socks5 := startSOCKS5Proxy(t, "user", "s3cret")
+
+	tests := []struct {
+		urls  []string
+		index int
+	}{
+		{[]string{"socks5://user:s3cret@" + closedAddress(t), "http://user:s3cret@" + httpProxy}, 0},
+		{[]string{"http://user:s3cret@" + httpProxy, "socks5://user:wr0ng@" + socks5}, 1},
+		{[]string{"http://user:s3cret@" + httpProxy, "socks5://user:s3cret@" + closedAddress(t)}, 0},
+		{[]string{"socks5://user:s3cret@" + socks5, "http://user:wr0ng@" + httpProxy}, 1},
+	}
+
+	for _, test := range tests {
+		chain := chainFor(t, test.urls...)
+
+		_, err := chain.Dial("tcp", strings.TrimPrefix(target.URL, "http://"))
+		if chainErr, ok := err.(*prox.ChainError); assert.True(t, ok, "%v: %v", chain, err) {
+			assert.Equal(t, test.index, chainErr.Index, "%v: %v", chain, err)
+			assert.Equal(t, 2, chainErr.Hops)
+			assert.NotContains(t, err.Error(), "s3cret")
+			assert.NotContains(t, err.Error(), "wr0ng")
+		}
+	}
+}
+
+// TestNewChainInvalid tests that empty chains, and chains with proxies which can't be chained, are rejected.
+func TestNewChainInvalid(t *testing.T) {
+	_, err := prox.NewChain()
+	assert.NotNil(t, err)
+
+	p := proxyFor(t, "http://1.1.1.1:80")
+	p.URL.Scheme = "ftp"
+
+	_, err = prox.NewChain(proxyFor(t, "socks5://1.1.1.1:1080"), p)
+	assert.NotNil(t, err)
+}
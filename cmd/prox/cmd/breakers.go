@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ollybritton/prox"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// defaultBreakerPath returns the default location of the file storing the state of the circuit breakers.
+func defaultBreakerPath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+
+	return filepath.Join(dir, "prox", "breakers.json")
+}
+
+// loadBreakers loads the circuit breakers configured by the persistent breaker flags.
+func loadBreakers(cmd *cobra.Command, logger *logrus.Logger) (*prox.Breakers, string) {
+	threshold, err := cmd.Flags().GetInt("breaker-threshold")
+	if err != nil {
+		logger.Errorf("couldn't get 'breaker-threshold' flag: %v", err)
+	}
+
+	cooldown, err := cmd.Flags().GetDuration("breaker-cooldown")
+	if err != nil {
+		logger.Errorf("couldn't get 'breaker-cooldown' flag: %v", err)
+	}
+
+	path, err := cmd.Flags().GetString("breaker-state")
+	if err != nil {
+		logger.Errorf("couldn't get 'breaker-state' flag: %v", err)
+	}
+
+	breakers := prox.NewBreakers(threshold, cooldown)
+	if err := breakers.Load(path); err != nil {
+		logger.Errorf("couldn't load circuit breakers: %v", err)
+	}
+
+	return breakers, path
+}
+
+func init() {
+	rootCmd.PersistentFlags().Int("breaker-threshold", 3, "consecutive failures before a provider is skipped")
+	rootCmd.PersistentFlags().Duration("breaker-cooldown", 10*time.Minute, "how long a failing provider is skipped for")
+	rootCmd.PersistentFlags().String("breaker-state", defaultBreakerPath(), "file storing the state of the circuit breakers")
+}
@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/olekukonko/tablewriter"
+	"github.com/ollybritton/prox"
+	"github.com/ollybritton/prox/providers"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// readAddresses returns the addresses given as arguments, or the lines of stdin if there are none.
+func readAddresses(args []string) ([]string, error) {
+	if len(args) != 0 {
+		return args, nil
+	}
+
+	addresses := []string{}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		addresses = append(addresses, line)
+	}
+
+	return addresses, scanner.Err()
+}
+
+// checkCmd represents the check command
+var checkCmd = &cobra.Command{
+	Use:   "check [addresses]",
+	Short: "find out which protocols proxies speak",
+	Long: `find out which protocols proxies speak, by trying SOCKS5, SOCKS4 and HTTP CONNECT in parallel
+
+Addresses are given as arguments, or read from stdin one per line. Any scheme they have is ignored. With
+--parent-proxy and --parent-connections, proxies are checked through the parent proxy.
+
+To check a single proxy, run
+  prox check 1.2.3.4:8080
+
+To turn a list of bare addresses into proxy URLs, run
+  prox check --plain < list.txt`,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := logrus.New()
+
+		timeout, err := cmd.Flags().GetDuration("timeout")
+		if err != nil {
+			logger.Errorf("couldn't get timeout flag: %v", err)
+			return
+		}
+
+		target, err := cmd.Flags().GetString("target")
+		if err != nil {
+			logger.Errorf("couldn't get target flag: %v", err)
+			return
+		}
+
+		plain, err := cmd.Flags().GetBool("plain")
+		if err != nil {
+			logger.Errorf("couldn't get plain flag: %v", err)
+			return
+		}
+
+		showCredentials, err := cmd.Flags().GetBool("show-credentials")
+		if err != nil {
+			logger.Errorf("couldn't get show-credentials flag: %v", err)
+			return
+		}
+
+		raw, err := readAddresses(args)
+		if err != nil {
+			logger.Errorf("couldn't read addresses: %v", err)
+			return
+		}
+
+		addresses := []string{}
+		urls := []*url.URL{}
+
+		for _, address := range raw {
+			u, err := providers.ParseAddress(address, "http")
+			if err != nil {
+				logger.Errorf("%v", err)
+				continue
+			}
+
+			addresses = append(addresses, u.Host)
+			urls = append(urls, u)
+		}
+
+		prober := providers.NewProber()
+		prober.Timeout = timeout
+		prober.Target = target
+		prober.Dialer = prox.ConnectionDialer()
+
+		results := prober.ProbeAll(context.Background(), addresses)
+
+		data := [][]string{}
+		unknown := color.New(color.FgRed, color.Bold).Sprint("none")
+
+		for i, result := range results {
+			proxy := ""
+			capabilities := ""
+
+			if result.Scheme() != "" {
+				u := urls[i]
+				u.Scheme = result.Scheme()
+
+				capabilities = (result.Capabilities[u.Scheme] | providers.ImpliedCapabilities(u)).String()
+
+				proxy = providers.RedactURL(u)
+				if showCredentials {
+					proxy = u.String()
+				}
+			}
+
+			if plain {
+				if proxy != "" {
+					fmt.Println(proxy)
+				}
+
+				continue
+			}
+
+			protocols := strings.Join(result.Protocols, ", ")
+			if protocols == "" {
+				protocols = unknown
+			}
+
+			data = append(data, []string{result.Address, protocols, capabilities, proxy})
+		}
+
+		if plain || len(data) == 0 {
+			return
+		}
+
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Address", "Protocols", "Capabilities", "Proxy"})
+		table.SetBorder(false)
+		table.SetAutoWrapText(false)
+		table.AppendBulk(data)
+
+		fmt.Println()
+		table.Render()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(checkCmd)
+
+	checkCmd.Flags().DurationP("timeout", "d", 5*time.Second, "how long to wait for each proxy to answer")
+	checkCmd.Flags().String("target", "1.1.1.1:443", "IPv4 address and port to ask for in SOCKS4 and CONNECT requests")
+
+	checkCmd.Flags().Bool("plain", false, "only print a URL for each proxy which speaks a known protocol")
+	checkCmd.Flags().Bool("show-credentials", false, "print the credentials of authenticated proxies")
+}
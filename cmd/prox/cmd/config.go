@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/ollybritton/prox"
+	"github.com/ollybritton/prox/providers"
+	"gopkg.in/yaml.v2"
+)
+
+// config is the contents of the config file.
+type config struct {
+	// Providers are declarative provider definitions, which can then be used by name like the built-in providers.
+	Providers []providers.Definition `yaml:"providers"`
+
+	// Commands are providers which run an external program, and can also be used by name.
+	Commands []providers.Command `yaml:"commands"`
+}
+
+// defaultConfigPath returns the default location of the config file.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+
+	return filepath.Join(dir, "prox", "config.yaml")
+}
+
+// initConfig reads the config file and registers the providers and commands it defines. It is fine for the
+// default config file to be missing, but not one given with --config.
+func initConfig() {
+	path := cfgFile
+	if path == "" {
+		path = defaultConfigPath()
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && cfgFile == "" {
+		return
+	} else if err != nil {
+		fmt.Printf("couldn't read config file: %v\n", err)
+		os.Exit(1)
+	}
+
+	cfg := config{}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		fmt.Printf("couldn't parse config file %v: %v\n", path, err)
+		os.Exit(1)
+	}
+
+	if err := prox.RegisterDefinitions(cfg.Providers...); err != nil {
+		fmt.Printf("couldn't register providers from config file %v: %v\n", path, err)
+		os.Exit(1)
+	}
+
+	if err := prox.RegisterCommands(cfg.Commands...); err != nil {
+		fmt.Printf("couldn't register commands from config file %v: %v\n", path, err)
+		os.Exit(1)
+	}
+}
@@ -2,10 +2,12 @@ package cmd
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/logrusorgru/aurora"
 	"github.com/ollybritton/prox"
+	"github.com/ollybritton/prox/providers"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -23,7 +25,7 @@ func pprint(p prox.Proxy) {
 		aurora.Sprintf(
 			aurora.White("(%v) %v [%v]"),
 			aurora.Green(country).Bold(),
-			aurora.BrightWhite(p.URL.String()),
+			aurora.BrightWhite(p.Redacted()),
 			aurora.Magenta(p.Provider).Italic(),
 		),
 	)
@@ -38,7 +40,7 @@ var findCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		logger := logrus.New()
 
-		providers, err := cmd.Flags().GetStringSlice("providers")
+		providerNames, err := cmd.Flags().GetStringSlice("providers")
 		if err != nil {
 			logger.Errorf("couldn't get 'providers' flag: %v", err)
 			return
@@ -50,6 +52,30 @@ var findCmd = &cobra.Command{
 			return
 		}
 
+		countries, err := cmd.Flags().GetStringSlice("countries")
+		if err != nil {
+			logger.Errorf("couldn't get countries flag: %v", err)
+			return
+		}
+
+		families, err := cmd.Flags().GetStringSlice("families")
+		if err != nil {
+			logger.Errorf("couldn't get families flag: %v", err)
+			return
+		}
+
+		capabilityNames, err := cmd.Flags().GetStringSlice("capabilities")
+		if err != nil {
+			logger.Errorf("couldn't get capabilities flag: %v", err)
+			return
+		}
+
+		capabilities, err := providers.ParseCapabilities(capabilityNames...)
+		if err != nil {
+			logger.Errorf("invalid capabilities flag: %v", err)
+			return
+		}
+
 		duration, err := cmd.Flags().GetDuration("duration")
 		if err != nil {
 			logger.Errorf("couldn't get duration flag: %v", err)
@@ -68,13 +94,63 @@ var findCmd = &cobra.Command{
 			return
 		}
 
+		showCredentials, err := cmd.Flags().GetBool("show-credentials")
+		if err != nil {
+			logger.Errorf("couldn't get show-credentials flag: %v", err)
+			return
+		}
+
+		breakers, breakerPath := loadBreakers(cmd, logger)
+		defer func() {
+			if err := breakers.Save(breakerPath); err != nil {
+				logger.Errorf("couldn't save circuit breakers: %v", err)
+			}
+		}()
+
+		// Asking for socks4a or socks5h proxies finds the same proxies as socks4 and socks5, but has them resolve
+		// hostnames. Every SOCKS proxy is then given the remote DNS scheme, so plain socks4 and socks5 are filtered
+		// out.
+		remoteDNS := false
+		for _, t := range types {
+			remoteDNS = remoteDNS || providers.BaseScheme(t) != strings.ToLower(t)
+		}
+
+		// The providers keep their names, so that their circuit breakers are shared with other runs.
+		ps := prox.GetProviders(providerNames...)
+		if remoteDNS {
+			for i := range ps {
+				ps[i].InternalProvider = prox.WithRemoteDNS(ps[i]).InternalProvider
+			}
+		}
+
+		filters := []prox.Filter{prox.FilterProxyTypes(types...)}
+		if len(countries) != 0 {
+			for i := range countries {
+				countries[i] = strings.ToUpper(countries[i])
+			}
+
+			filters = append(filters, prox.FilterAllowCountries(countries))
+		}
+
+		if len(families) != 0 {
+			filters = append(filters, prox.FilterAddressFamilies(families...))
+		}
+
+		if capabilities != 0 {
+			filters = append(filters, prox.FilterCapabilities(capabilities))
+		}
+
 		pool := prox.NewComplexPool(
-			prox.UseProviders(prox.GetProviders(providers...)...),
+			prox.UseProviders(ps...),
 			prox.OptionReloadWhenEmpty(true),
-
-			prox.OptionAddFilters(
-				prox.FilterProxyTypes(types...),
-			),
+			prox.OptionBreakers(breakers),
+
+			prox.OptionQueryHints(providers.Hints{
+				Countries: countries,
+				Schemes:   types,
+				SSL:       capabilities.Has(providers.CapabilityConnect),
+			}),
+			prox.OptionAddFilters(filters...),
 		)
 
 		pool.SetTimeout(duration)
@@ -86,10 +162,13 @@ var findCmd = &cobra.Command{
 				return
 			}
 
-			if !plain {
+			switch {
+			case !plain:
 				pprint(p)
-			} else {
+			case showCredentials:
 				fmt.Println(p.URL)
+			default:
+				fmt.Println(p.Redacted())
 			}
 
 		}
@@ -99,18 +178,16 @@ var findCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(findCmd)
 
-	defaultProviders := []string{}
-	for providerName := range prox.Providers {
-		if providerName != "Static" {
-			defaultProviders = append(defaultProviders, providerName)
-		}
-	}
+	findCmd.Flags().StringSliceP("providers", "p", prox.DefaultRegistry.Defaults(), "providers to fetch")
+	findCmd.Flags().StringSliceP("types", "t", []string{"HTTP", "SOCKS4", "SOCKS5"}, "proxy types to fetch: HTTP, HTTPS, SOCKS4, SOCKS4A, SOCKS5 or SOCKS5H")
 
-	findCmd.Flags().StringSliceP("providers", "p", defaultProviders, "providers to fetch")
-	findCmd.Flags().StringSliceP("types", "t", []string{"HTTP", "SOCKS4", "SOCKS5"}, "proxy types to fetch")
+	findCmd.Flags().StringSliceP("countries", "c", []string{}, "countries to fetch proxies from, such as GB or US")
+	findCmd.Flags().StringSlice("families", []string{}, "address families to allow: ipv4, ipv6 or hostname")
+	findCmd.Flags().StringSlice("capabilities", []string{}, "capabilities to require: connect, tls, remote_dns, udp or auth")
 
 	findCmd.Flags().DurationP("duration", "d", 10*time.Second, "duration to fetch for")
 	findCmd.Flags().IntP("number", "n", 100, "number of proxies to return")
 
 	findCmd.Flags().Bool("plain", false, "use a plain output")
+	findCmd.Flags().Bool("show-credentials", false, "print the credentials of authenticated proxies in plain output")
 }
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/ollybritton/prox"
+	"github.com/spf13/cobra"
+)
+
+// providersCmd represents the providers command
+var providersCmd = &cobra.Command{
+	Use:     "providers",
+	Aliases: []string{"list"},
+	Short:   "list the providers available to prox",
+	Long: `list the providers available to prox, including those defined in the config file
+
+Providers marked as default are used when no providers are given to find or status.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		data := [][]string{}
+
+		for _, name := range prox.DefaultRegistry.List() {
+			info, _ := prox.DefaultRegistry.Info(name)
+
+			data = append(data, []string{
+				name,
+				yesNo(info.Default),
+				yesNo(info.NeedsNetwork),
+				strings.Join(info.Schemes, ", "),
+				info.Description,
+			})
+		}
+
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Name", "Default", "Network", "Schemes", "Description"})
+		table.SetBorder(false)
+		table.SetAutoWrapText(false)
+		table.AppendBulk(data)
+
+		fmt.Println()
+		table.Render()
+	},
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+
+	return "no"
+}
+
+func init() {
+	rootCmd.AddCommand(providersCmd)
+}
@@ -4,11 +4,18 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/ollybritton/prox"
+	"github.com/ollybritton/prox/providers"
 	"github.com/spf13/cobra"
 )
 
 var cfgFile string
 
+var (
+	parentProxy       string
+	parentConnections bool
+)
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "prox [command] [flags]",
@@ -27,3 +34,26 @@ func Execute() {
 		os.Exit(1)
 	}
 }
+
+func init() {
+	cobra.OnInitialize(initConfig, initParentProxy)
+
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is "+defaultConfigPath()+")")
+	rootCmd.PersistentFlags().StringVar(&parentProxy, "parent-proxy", "", "proxy to fetch providers through (default is from HTTP_PROXY, HTTPS_PROXY and NO_PROXY)")
+	rootCmd.PersistentFlags().BoolVar(&parentConnections, "parent-connections", false, "also connect to proxies through the parent proxy")
+}
+
+// initParentProxy sets the parent proxy given with --parent-proxy, if there is one.
+func initParentProxy() {
+	if parentProxy == "" {
+		return
+	}
+
+	u, err := providers.ParseAddress(parentProxy, "http")
+	if err != nil {
+		fmt.Printf("invalid parent proxy: %v\n", err)
+		os.Exit(1)
+	}
+
+	prox.SetParentProxy(u, parentConnections)
+}
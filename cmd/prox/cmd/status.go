@@ -1,11 +1,14 @@
 package cmd
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/fatih/color"
@@ -19,29 +22,48 @@ import (
 
 var errInvalidProvider = errors.New("invalid provider")
 
-// CheckStatus checks the status of a single provider.
-func CheckStatus(providerName string) (active bool, amount int, err error) {
-	provider := prox.Providers[providerName]
+// providerStatus is the status of a single provider, as printed by `prox status --json`.
+type providerStatus struct {
+	Available bool   `json:"available"`
+	Breaker   string `json:"breaker"`
+	providers.Report
+}
 
-	if provider.InternalProvider == nil {
-		return false, 0, errInvalidProvider
+// CheckStatus checks the status of a single provider and returns a report of the fetch.
+func CheckStatus(providerName string) (providers.Report, error) {
+	provider, ok := prox.Lookup(providerName)
+	if !ok {
+		return providers.Report{}, errInvalidProvider
 	}
 
-	set := providers.NewSet()
 	timeout := 10 * time.Second
 
-	ps, err := provider.InternalProvider(set, timeout)
-	if err != nil {
-		return false, 0, err
+	_, report, err := providers.Fetch(provider.Name, provider.InternalProvider, timeout)
+	return report, err
+}
+
+// formatRejected formats the rows rejected by a provider, such as "3 (bad_country 2, malformed_ip 1)".
+func formatRejected(report providers.Report) string {
+	total := report.TotalRejected()
+	if total == 0 {
+		return "0"
 	}
 
-	return true, len(ps), nil
+	reasons := []string{}
+	for reason, n := range report.Rejected {
+		if n != 0 {
+			reasons = append(reasons, fmt.Sprintf("%v %d", reason, n))
+		}
+	}
+	sort.Strings(reasons)
+
+	return fmt.Sprintf("%d (%v)", total, strings.Join(reasons, ", "))
 }
 
 // statusCmd represents the status command
 var statusCmd = &cobra.Command{
 	Use:     "status",
-	Aliases: []string{"check", "monitor"},
+	Aliases: []string{"monitor"},
 	Short:   "get the status of the providers used by prox",
 	Long: `get the status of the providers used by prox
 
@@ -49,51 +71,104 @@ To see all providers checked, run
   prox status
 
 To see certain providers checked, run
-  prox status --providers FreeProxyLists,ProxyScrape`,
+  prox status --providers FreeProxyLists,ProxyScrape
+
+To get a detailed report for each provider as JSON, run
+  prox status --json`,
 	Run: func(cmd *cobra.Command, args []string) {
 		logger := logrus.New()
 
-		providers, err := cmd.Flags().GetStringSlice("providers")
+		providerNames, err := cmd.Flags().GetStringSlice("providers")
 		if err != nil {
 			logger.Errorf("couldn't get 'providers' flag: %v", err)
 		}
 
-		data := [][]string{}
-		total := 0
-
-		available := color.New(color.FgGreen, color.Bold).Sprint("Available")
-		unavailable := color.New(color.FgRed, color.Bold).Sprint("Unavailable")
+		asJSON, err := cmd.Flags().GetBool("json")
+		if err != nil {
+			logger.Errorf("couldn't get 'json' flag: %v", err)
+			return
+		}
 
-		shareColor := color.New(color.FgCyan)
+		breakers, breakerPath := loadBreakers(cmd, logger)
+		statuses := []providerStatus{}
 
-		for _, providerName := range providers {
+		for _, providerName := range providerNames {
 			logger.Infof("Checking provider %v", providerName)
 
-			_, amount, err := CheckStatus(providerName)
+			report, err := CheckStatus(providerName)
 			if err == errInvalidProvider {
 				logger.Errorf("No provider named %v", providerName)
 				continue
 			} else if err != nil {
 				logger.Errorf("[%v] Unavailable", providerName)
-
-				data = append(data, []string{
-					providerName, unavailable, "0", "",
-				})
-
-				continue
+				breakers.Get(providerName).Failure()
+			} else {
+				breakers.Get(providerName).Success()
 			}
 
-			data = append(data, []string{
-				providerName, available, fmt.Sprint(amount), "",
+			statuses = append(statuses, providerStatus{
+				Available: err == nil,
+				Breaker:   breakers.Get(providerName).State().String(),
+				Report:    report,
 			})
+		}
 
-			total += amount
+		if err := breakers.Save(breakerPath); err != nil {
+			logger.Errorf("couldn't save circuit breakers: %v", err)
+		}
+
+		if asJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+
+			if err := encoder.Encode(statuses); err != nil {
+				logger.Errorf("couldn't encode status: %v", err)
+			}
+
+			return
 		}
 
-		if len(data) == 0 {
+		if len(statuses) == 0 {
 			return
 		}
 
+		data := [][]string{}
+		total := 0
+
+		available := color.New(color.FgGreen, color.Bold).Sprint("Available")
+		unavailable := color.New(color.FgRed, color.Bold).Sprint("Unavailable")
+
+		shareColor := color.New(color.FgCyan)
+
+		for _, status := range statuses {
+			state := available
+			found := status.Unique
+
+			if !status.Available {
+				state = unavailable
+				found = 0
+			}
+
+			breaker := status.Breaker
+			if failures := breakers.Get(status.Provider).Failures(); failures != 0 {
+				breaker = fmt.Sprintf("%v (%d failures)", breaker, failures)
+			}
+
+			data = append(data, []string{
+				status.Provider,
+				state,
+				fmt.Sprint(found),
+				fmt.Sprint(status.Duplicate),
+				formatRejected(status.Report),
+				fmt.Sprint(status.HTTPErrors),
+				status.Duration.Round(time.Millisecond).String(),
+				breaker,
+				"",
+			})
+
+			total += found
+		}
+
 		for _, entry := range data {
 			found, _ := strconv.Atoi(entry[2])
 			percentage := 0.0
@@ -102,12 +177,12 @@ To see certain providers checked, run
 				percentage = math.Round((float64(found)/float64(total))*10000) / 100
 			}
 
-			entry[3] = shareColor.Sprintf("%.2f%%", percentage)
+			entry[8] = shareColor.Sprintf("%.2f%%", percentage)
 		}
 
 		table := tablewriter.NewWriter(os.Stdout)
-		table.SetHeader([]string{"Name", "Status", "Proxies Found", "Share"})
-		table.SetFooter([]string{"", "TOTAL", fmt.Sprint(total), ""})
+		table.SetHeader([]string{"Name", "Status", "Proxies Found", "Duplicates", "Rejected", "HTTP Errors", "Duration", "Breaker", "Share"})
+		table.SetFooter([]string{"", "TOTAL", fmt.Sprint(total), "", "", "", "", "", ""})
 
 		table.SetBorder(false)
 		table.SetColumnAlignment([]int{
@@ -115,6 +190,11 @@ To see certain providers checked, run
 			tablewriter.ALIGN_DEFAULT,
 			tablewriter.ALIGN_LEFT,
 			tablewriter.ALIGN_LEFT,
+			tablewriter.ALIGN_LEFT,
+			tablewriter.ALIGN_LEFT,
+			tablewriter.ALIGN_LEFT,
+			tablewriter.ALIGN_LEFT,
+			tablewriter.ALIGN_LEFT,
 		})
 
 		table.AppendBulk(data)
@@ -128,12 +208,6 @@ To see certain providers checked, run
 func init() {
 	rootCmd.AddCommand(statusCmd)
 
-	defaultProviders := []string{}
-	for providerName := range prox.Providers {
-		if providerName != "Static" {
-			defaultProviders = append(defaultProviders, providerName)
-		}
-	}
-
-	statusCmd.Flags().StringSliceP("providers", "p", defaultProviders, "Provider(s) to check for.")
+	statusCmd.Flags().StringSliceP("providers", "p", prox.DefaultRegistry.Defaults(), "Provider(s) to check for.")
+	statusCmd.Flags().Bool("json", false, "print a report for each provider as JSON")
 }
@@ -0,0 +1,312 @@
+package prox
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ollybritton/prox/providers"
+)
+
+// CachedProvider creates a provider which reuses the proxies found by the provider given for the duration of
+// the ttl. Once they are older than the ttl, the stale proxies are still returned straight away while the
+// provider is fetched again in the background. The provider is only fetched in the foreground if there are no
+// proxies cached at all, for example the first time it is used or if the last fetch failed. Proxies are cached
+// separately for each combination of hints and parent proxy they are requested with.
+func CachedProvider(provider Provider, ttl time.Duration) Provider {
+	name := fmt.Sprintf("Cached{%v}", provider.Name)
+
+	type entry struct {
+		proxies    []providers.Proxy
+		fetchedAt  time.Time
+		refreshing bool
+	}
+
+	var (
+		mu      sync.Mutex
+		entries = make(map[string]*entry)
+	)
+
+	fetch := func(e *entry, req providers.Request) ([]providers.Proxy, error) {
+		ps, err := provider.InternalProvider(providers.NewSet(), req)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		e.refreshing = false
+
+		if err != nil || len(ps) == 0 {
+			logger.Debugf("prox: cannot refresh cached provider %v: %v", name, err)
+			return ps, err
+		}
+
+		e.proxies = ps
+		e.fetchedAt = time.Now()
+
+		return ps, nil
+	}
+
+	return Provider{name, func(proxies *providers.Set, req providers.Request) ([]providers.Proxy, error) {
+		key := fmt.Sprintf("%v %v", req.Hints, req.Parent)
+
+		mu.Lock()
+
+		e, ok := entries[key]
+		if !ok {
+			e = &entry{}
+			entries[key] = e
+		}
+
+		ps := e.proxies
+		if len(ps) != 0 && time.Since(e.fetchedAt) > ttl && !e.refreshing {
+			logger.Debugf("prox: cached provider %v is stale, refreshing in the background", name)
+
+			e.refreshing = true
+			go fetch(e, req)
+		}
+
+		mu.Unlock()
+
+		if len(ps) == 0 {
+			var err error
+
+			ps, err = fetch(e, req)
+			if err != nil {
+				return []providers.Proxy{}, err
+			}
+		}
+
+		for _, p := range ps {
+			proxies.Add(p)
+		}
+
+		return proxies.List(), nil
+	}}
+}
+
+// FilteredProvider creates a provider which only keeps the proxies found by the provider given which pass every
+// filter. Proxies which fail a filter are counted as rejected in the provider's report.
+func FilteredProvider(provider Provider, filters ...Filter) Provider {
+	name := fmt.Sprintf("Filtered{%v}", provider.Name)
+
+	return Provider{name, func(proxies *providers.Set, req providers.Request) ([]providers.Proxy, error) {
+		found := providers.NewSet()
+		_, err := provider.InternalProvider(found, req)
+
+		proxies.Merge(found, func(p providers.Proxy) bool {
+			proxy := CastProxy(p)
+
+			for _, filter := range filters {
+				if !filter(proxy) {
+					return false
+				}
+			}
+
+			return true
+		})
+
+		ps := proxies.List()
+		if len(ps) == 0 {
+			if err != nil {
+				return ps, err
+			}
+
+			return ps, fmt.Errorf("providers (%v): no proxies passed the filters", name)
+		}
+
+		return ps, nil
+	}}
+}
+
+// CappedProvider creates a provider which keeps at most n of the proxies found by the provider given: the first n
+// when sorted by URL, so the same proxies are kept each time the provider finds the same ones.
+func CappedProvider(provider Provider, n int) Provider {
+	name := fmt.Sprintf("Capped{%v}", provider.Name)
+
+	return Provider{name, func(proxies *providers.Set, req providers.Request) ([]providers.Proxy, error) {
+		found := providers.NewSet()
+		ps, err := provider.InternalProvider(found, req)
+
+		sorted := append([]providers.Proxy{}, ps...)
+		sort.Slice(sorted, func(i, j int) bool {
+			return sorted[i].URL.String() < sorted[j].URL.String()
+		})
+
+		keep := providers.NewSet()
+		for _, p := range sorted {
+			if keep.Length() >= n {
+				break
+			}
+
+			keep.Add(p)
+		}
+
+		proxies.Merge(found, keep.In)
+
+		ps = proxies.List()
+		if len(ps) == 0 {
+			return ps, err
+		}
+
+		return ps, nil
+	}}
+}
+
+// FirstSuccessfulProvider creates a provider which tries each of the providers given in order, and uses the
+// proxies from the first one which finds any without an error. The providers share the timeout between them.
+func FirstSuccessfulProvider(givenProviders ...Provider) Provider {
+	names := []string{}
+
+	for _, provider := range givenProviders {
+		names = append(names, provider.Name)
+	}
+
+	name := fmt.Sprintf("First{%v}", strings.Join(names, "|"))
+
+	return Provider{name, func(proxies *providers.Set, req providers.Request) ([]providers.Proxy, error) {
+		deadline := time.Now().Add(req.Timeout)
+		errs := []string{}
+
+		for _, provider := range givenProviders {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				errs = append(errs, "timed out")
+				break
+			}
+
+			found := providers.NewSet()
+			ps, err := provider.InternalProvider(found, req.WithTimeout(remaining))
+
+			if err == nil && len(ps) != 0 {
+				proxies.Merge(found, nil)
+				return proxies.List(), nil
+			}
+
+			if err == nil {
+				err = fmt.Errorf("no proxies found")
+			}
+
+			logger.Debugf("prox: provider %v failed in %v, trying the next one: %v", provider.Name, name, err)
+			errs = append(errs, fmt.Sprintf("%v: %v", provider.Name, err))
+		}
+
+		return []providers.Proxy{}, fmt.Errorf("providers (%v): every provider failed: %v", name, strings.Join(errs, "; "))
+	}}
+}
+
+// RetryingProvider creates a provider which tries the provider given up to attempts times until it finds
+// proxies without an error. The wait between attempts starts at backoff and doubles after each one, and the
+// attempts share the timeout between them. The provider is always tried at least once.
+func RetryingProvider(provider Provider, attempts int, backoff time.Duration) Provider {
+	name := fmt.Sprintf("Retrying{%v}", provider.Name)
+
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	return Provider{name, func(proxies *providers.Set, req providers.Request) ([]providers.Proxy, error) {
+		deadline := time.Now().Add(req.Timeout)
+		wait := backoff
+
+		var err error
+
+		for attempt := 1; attempt <= attempts; attempt++ {
+			found := providers.NewSet()
+
+			var ps []providers.Proxy
+			ps, err = provider.InternalProvider(found, req.WithTimeout(time.Until(deadline)))
+
+			if err == nil && len(ps) != 0 {
+				proxies.Merge(found, nil)
+				return proxies.List(), nil
+			}
+
+			if err == nil {
+				err = fmt.Errorf("no proxies found")
+			}
+
+			if attempt == attempts || time.Until(deadline) <= wait {
+				break
+			}
+
+			logger.Debugf("prox: attempt %d of %v failed, retrying in %v: %v", attempt, name, wait, err)
+
+			time.Sleep(wait)
+			wait *= 2
+		}
+
+		return []providers.Proxy{}, fmt.Errorf("providers (%v): no proxies could be gathered: %v", name, err)
+	}}
+}
+
+// WithCredentials creates a provider which adds the username and password given to every proxy found by the
+// provider given, replacing any credentials they already have. For SOCKS4 proxies, the username is used as the
+// user ID and the password is ignored. If password is empty, only the username is added.
+func WithCredentials(provider Provider, username, password string) Provider {
+	name := fmt.Sprintf("Authenticated{%v}", provider.Name)
+
+	user := url.UserPassword(username, password)
+	if password == "" {
+		user = url.User(username)
+	}
+
+	return mapProvider(name, provider, func(p providers.Proxy) providers.Proxy {
+		u := *p.URL
+		u.User = user
+
+		p.URL = &u
+		return p
+	})
+}
+
+// WithCapabilities creates a provider which declares that every proxy found by the provider given has the
+// capabilities given, on top of any they already have. It is useful for private lists whose proxies are known to
+// support things their scheme doesn't say, such as UDP.
+func WithCapabilities(provider Provider, capabilities providers.Capability) Provider {
+	name := fmt.Sprintf("Capable{%v}", provider.Name)
+
+	return mapProvider(name, provider, func(p providers.Proxy) providers.Proxy {
+		p.Capabilities |= capabilities
+		return p
+	})
+}
+
+// mapProvider creates a provider with the name given which changes every proxy found by the provider given with
+// fn.
+func mapProvider(name string, provider Provider, fn func(providers.Proxy) providers.Proxy) Provider {
+	return Provider{name, func(proxies *providers.Set, req providers.Request) ([]providers.Proxy, error) {
+		found := providers.NewSet()
+		ps, err := provider.InternalProvider(found, req)
+
+		for _, p := range ps {
+			proxies.Add(fn(p))
+		}
+
+		proxies.MergeCounts(found)
+
+		ps = proxies.List()
+		if len(ps) == 0 {
+			return ps, err
+		}
+
+		return ps, nil
+	}}
+}
+
+// WithRemoteDNS creates a provider which gives every SOCKS proxy found by the provider given the scheme which has
+// the proxy resolve hostnames, socks4a or socks5h, so that requests through them never look up hostnames
+// locally. Other proxies are left as they are.
+func WithRemoteDNS(provider Provider) Provider {
+	name := fmt.Sprintf("RemoteDNS{%v}", provider.Name)
+
+	return mapProvider(name, provider, func(p providers.Proxy) providers.Proxy {
+		u := *p.URL
+		u.Scheme = providers.RemoteDNSScheme(u.Scheme)
+
+		p.URL = &u
+		return p
+	})
+}
@@ -0,0 +1,156 @@
+package prox_test
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ollybritton/prox"
+	"github.com/ollybritton/prox/providers"
+	"github.com/stretchr/testify/assert"
+)
+
+func fetchProvider(provider prox.Provider) ([]providers.Proxy, providers.Report, error) {
+	return providers.Fetch(provider.Name, provider.InternalProvider, time.Second)
+}
+
+// sortedURLs returns the URLs of the proxies given, sorted.
+func sortedURLs(ps []providers.Proxy) []string {
+	urls := []string{}
+	for _, p := range ps {
+		urls = append(urls, p.URL.String())
+	}
+	sort.Strings(urls)
+
+	return urls
+}
+
+// TestCachedProvider tests that a cached provider reuses its proxies until they expire, and then refreshes them
+// in the background while returning the stale ones.
+func TestCachedProvider(t *testing.T) {
+	provider := prox.CachedProvider(sequenceProvider(
+		"Sequence",
+		[]string{"http://1.1.1.1:80"},
+		[]string{"http://2.2.2.2:80"},
+	), 50*time.Millisecond)
+
+	assert.Equal(t, "Cached{Sequence}", provider.Name)
+
+	for i := 0; i < 2; i++ {
+		ps, _, err := fetchProvider(provider)
+		assert.Nil(t, err)
+		assert.Equal(t, "http://1.1.1.1:80", ps[0].URL.String())
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	ps, _, err := fetchProvider(provider)
+	assert.Nil(t, err)
+	assert.Equal(t, "http://1.1.1.1:80", ps[0].URL.String(), "stale proxies should be returned while refreshing")
+
+	assert.Eventually(t, func() bool {
+		ps, _, _ := fetchProvider(provider)
+		return len(ps) == 1 && ps[0].URL.String() == "http://2.2.2.2:80"
+	}, time.Second, 10*time.Millisecond)
+}
+
+// TestCachedProviderRequests tests that a cached provider keeps the proxies found for different hints apart.
+func TestCachedProviderRequests(t *testing.T) {
+	provider := prox.CachedProvider(sequenceProvider(
+		"Sequence",
+		[]string{"http://1.1.1.1:80"},
+		[]string{"socks5://2.2.2.2:1080"},
+	), time.Minute)
+
+	socks := providers.Request{Timeout: time.Second, Hints: providers.Hints{Schemes: []string{"socks5"}}}
+
+	ps, _, err := fetchProvider(provider)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"http://1.1.1.1:80"}, sortedURLs(ps))
+
+	ps, _, err = providers.FetchRequest(provider.Name, provider.InternalProvider, socks)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"socks5://2.2.2.2:1080"}, sortedURLs(ps), "proxies cached for other hints shouldn't be used")
+
+	ps, _, err = fetchProvider(provider)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"http://1.1.1.1:80"}, sortedURLs(ps))
+}
+
+// TestFilteredProvider tests that proxies which fail a filter are discarded and counted in the report.
+func TestFilteredProvider(t *testing.T) {
+	provider := prox.FilteredProvider(
+		sequenceProvider("Sequence", []string{"http://1.1.1.1:80", "socks5://2.2.2.2:1080", "socks5://3.3.3.3:1080"}),
+		prox.FilterProxyTypes("SOCKS5"),
+	)
+
+	ps, report, err := fetchProvider(provider)
+	assert.Nil(t, err)
+	assert.Len(t, ps, 2)
+	assert.Equal(t, 1, report.Rejected[providers.RejectFiltered])
+
+	provider = prox.FilteredProvider(
+		sequenceProvider("Sequence", []string{"http://1.1.1.1:80"}),
+		prox.FilterProxyTypes("SOCKS5"),
+	)
+
+	_, _, err = fetchProvider(provider)
+	assert.NotNil(t, err, "an error should occur when no proxies pass the filters")
+}
+
+// TestCappedProvider tests that a capped provider keeps at most n proxies, and the same ones on every fetch.
+func TestCappedProvider(t *testing.T) {
+	provider := prox.CappedProvider(
+		sequenceProvider("Sequence",
+			[]string{"http://3.3.3.3:80", "http://1.1.1.1:80", "http://2.2.2.2:80"},
+			[]string{"http://2.2.2.2:80", "http://3.3.3.3:80", "http://1.1.1.1:80"},
+		),
+		2,
+	)
+
+	for i := 0; i < 2; i++ {
+		ps, _, err := fetchProvider(provider)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"http://1.1.1.1:80", "http://2.2.2.2:80"}, sortedURLs(ps))
+	}
+}
+
+// TestFirstSuccessfulProvider tests that providers are tried in order until one succeeds.
+func TestFirstSuccessfulProvider(t *testing.T) {
+	provider := prox.FirstSuccessfulProvider(
+		DummyProviderError,
+		DummyProviderEmpty,
+		sequenceProvider("Second", []string{"http://1.1.1.1:80"}),
+		sequenceProvider("Third", []string{"http://2.2.2.2:80"}),
+	)
+
+	ps, _, err := fetchProvider(provider)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"http://1.1.1.1:80"}, sortedURLs(ps))
+
+	_, _, err = fetchProvider(prox.FirstSuccessfulProvider(DummyProviderError, DummyProviderEmpty))
+	assert.NotNil(t, err)
+}
+
+// TestRetryingProvider tests that a failing provider is retried until it succeeds or runs out of attempts.
+func TestRetryingProvider(t *testing.T) {
+	flaky := sequenceProvider("Flaky", []string{}, []string{}, []string{"http://1.1.1.1:80"})
+
+	ps, _, err := fetchProvider(prox.RetryingProvider(flaky, 3, time.Millisecond))
+	assert.Nil(t, err)
+	assert.Len(t, ps, 1)
+
+	flaky = sequenceProvider("Flaky", []string{}, []string{}, []string{"http://1.1.1.1:80"})
+
+	_, _, err = fetchProvider(prox.RetryingProvider(flaky, 2, time.Millisecond))
+	assert.NotNil(t, err, "an error should occur when every attempt fails")
+
+	ps, _, err = fetchProvider(prox.RetryingProvider(sequenceProvider("Once", []string{"http://1.1.1.1:80"}), 0, time.Millisecond))
+	assert.Nil(t, err, "the provider should be tried at least once")
+	assert.Len(t, ps, 1)
+
+	_, _, err = fetchProvider(prox.RetryingProvider(DummyProviderError, 0, time.Millisecond))
+	if assert.NotNil(t, err) {
+		assert.NotContains(t, err.Error(), "<nil>")
+	}
+}
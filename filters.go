@@ -1,6 +1,7 @@
 package prox
 
 import (
+	"net/url"
 	"strings"
 	"time"
 
@@ -47,13 +48,14 @@ func FilterDisallowCountries(countries []string) Filter {
 	}
 }
 
-// FilterProxyTypes creates a filter that only allows specific types of proxies, such as HTTP or SOCKS5.
+// FilterProxyTypes creates a filter that only allows specific types of proxies, such as HTTP or SOCKS5. Types are
+// matched exactly, so SOCKS5H only allows socks5h:// proxies; see WithRemoteDNS.
 func FilterProxyTypes(ptypes ...string) Filter {
 	logger.Debugf("prox: applying proxy type filter, allowing the following types: %v", ptypes)
 	for i := range ptypes {
 		ptypes[i] = strings.ToLower(ptypes[i])
 
-		if ptypes[i] != "http" && ptypes[i] != "https" && ptypes[i] != "socks4" && ptypes[i] != "socks5" {
+		if !providers.KnownScheme(ptypes[i]) {
 			panic("prox: invalid proxy type specified for filter: " + ptypes[i])
 		}
 	}
@@ -72,6 +74,63 @@ func FilterProxyTypes(ptypes ...string) Filter {
 	}
 }
 
+// FilterAddressFamilies creates a filter that only allows proxies whose host is in one of the address families
+// given: providers.FamilyIPv4, providers.FamilyIPv6 or providers.FamilyHostname.
+func FilterAddressFamilies(families ...string) Filter {
+	logger.Debugf("prox: applying address family filter, allowing the following families: %v", families)
+	for i := range families {
+		families[i] = strings.ToLower(families[i])
+
+		if families[i] != providers.FamilyIPv4 && families[i] != providers.FamilyIPv6 && families[i] != providers.FamilyHostname {
+			panic("prox: invalid address family specified for filter: " + families[i])
+		}
+	}
+
+	return func(p *Proxy) bool {
+		family := p.Family()
+
+		for _, allowed := range families {
+			if family == allowed {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// FilterRequireIPv6 creates a filter that only allows proxies with an IPv6 address.
+func FilterRequireIPv6() Filter {
+	return FilterAddressFamilies(providers.FamilyIPv6)
+}
+
+// FilterExcludeIPv6 creates a filter that does not allow proxies with an IPv6 address.
+func FilterExcludeIPv6() Filter {
+	return FilterAddressFamilies(providers.FamilyIPv4, providers.FamilyHostname)
+}
+
+// FilterCapabilities creates a filter that only allows proxies known to have every capability given, such as
+// providers.CapabilityConnect|providers.CapabilityRemoteDNS. Capabilities implied by a proxy's URL count.
+func FilterCapabilities(capabilities providers.Capability) Filter {
+	logger.Debugf("prox: applying capability filter, requiring the following capabilities: %v", capabilities)
+	return func(p *Proxy) bool {
+		return p.Can(capabilities)
+	}
+}
+
+// FilterCheckCapabilities creates a filter like FilterCapabilities, but proxies which aren't already known to
+// have the capabilities are probed first, waiting at most timeout for each. See Proxy.CheckCapabilities.
+func FilterCheckCapabilities(capabilities providers.Capability, timeout time.Duration) Filter {
+	logger.Debugf("prox: applying capability check filter, requiring the following capabilities: %v", capabilities)
+	return func(p *Proxy) bool {
+		if p.Can(capabilities) {
+			return true
+		}
+
+		return p.CheckCapabilities(timeout).Has(capabilities)
+	}
+}
+
 // FilterProxySpeed creates a filter that only allows proxies if they can make a successful
 // request in a given timeframe.
 func FilterProxySpeed(speed time.Duration) Filter {
@@ -90,12 +149,19 @@ func FilterProxyConnection() Filter {
 	}
 }
 
-// ApplyFilters will apply filters to a list of proxies, and will return a new proxy list.
+// ApplyFilters will apply filters to a list of proxies, and will return a new proxy list. Any capabilities
+// found by the filters are kept.
 func ApplyFilters(proxies []providers.Proxy, filters []Filter) []providers.Proxy {
+	return applyFilters(proxies, filters, nil)
+}
+
+// applyFilters is like ApplyFilters, but the proxies the filters are given have the parent proxy given.
+func applyFilters(proxies []providers.Proxy, filters []Filter, parent *url.URL) []providers.Proxy {
 	newProxies := []providers.Proxy{}
 
 	for _, p := range proxies {
 		proxy := CastProxy(p)
+		proxy.Parent = parent
 
 		// If this is true by the end of the loop, a proxies will be allowed through
 		result := true
@@ -112,6 +178,8 @@ func ApplyFilters(proxies []providers.Proxy, filters []Filter) []providers.Proxy
 		}
 
 		if result == true {
+			// Filters which check proxies can find capabilities they didn't know they had.
+			p.Capabilities = proxy.Capabilities
 			newProxies = append(newProxies, p)
 		}
 
@@ -3,13 +3,11 @@ module github.com/ollybritton/prox
 go 1.13
 
 require (
-	github.com/Bogdan-D/go-socks4 v0.0.0-20160129084303-092515145880
-	github.com/PuerkitoBio/goquery v1.5.1 // indirect
+	github.com/PuerkitoBio/goquery v1.5.1
 	github.com/antchfx/htmlquery v1.2.3 // indirect
 	github.com/antchfx/xmlquery v1.2.4 // indirect
 	github.com/fatih/color v1.7.0
 	github.com/gobwas/glob v0.2.3 // indirect
-	github.com/gocolly/colly v1.2.0
 	github.com/kennygrant/sanitize v1.2.4 // indirect
 	github.com/logrusorgru/aurora v0.0.0-20191017060258-dc85c304c434
 	github.com/mattn/go-colorable v0.1.4 // indirect
@@ -23,8 +21,10 @@ require (
 	github.com/spf13/cobra v0.0.5
 	github.com/stretchr/testify v1.4.0
 	github.com/temoto/robotstxt v1.1.1 // indirect
-	golang.org/x/net v0.0.0-20200421231249-e086a090c8fd
+	golang.org/x/crypto v0.21.0
+	golang.org/x/net v0.21.0
 	google.golang.org/appengine v1.6.6 // indirect
+	gopkg.in/yaml.v2 v2.2.2
 	github.com/ollybritton/prox/providers v0.0.0-20200507110232-3f3e89b713ee
 )
 
@@ -0,0 +1,82 @@
+package prox_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ollybritton/prox"
+	"github.com/ollybritton/prox/providers"
+	"github.com/stretchr/testify/assert"
+)
+
+// listenIPv6 returns a listener on the IPv6 loopback address, skipping the test if IPv6 isn't available.
+func listenIPv6(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 is not available: %v", err)
+	}
+
+	return listener
+}
+
+// TestSOCKS5ProxyIPv6 tests that a SOCKS5 proxy with an IPv6 address can be used to reach an IPv6 target.
+func TestSOCKS5ProxyIPv6(t *testing.T) {
+	listener := listenIPv6(t)
+
+	target := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	target.Listener.Close()
+	target.Listener = listener
+	target.Start()
+	defer target.Close()
+
+	addr := startSOCKSServer(t, "[::1]:0", socks5Handler("", "", false))
+
+	p := proxyFor(t, "socks5://"+addr)
+	assert.Equal(t, providers.FamilyIPv6, p.Family())
+
+	status, err := get(t, p, target.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNoContent, status)
+}
+
+// TestSOCKS4ProxyIPv6Target tests that SOCKS4 proxies refuse IPv6 targets, which the protocol can't address.
+func TestSOCKS4ProxyIPv6Target(t *testing.T) {
+	addr := startSOCKS4Proxy(t, "")
+
+	_, err := get(t, proxyFor(t, "socks4://"+addr), "http://[::1]:80/")
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "IPv6")
+	}
+}
+
+// TestFilterAddressFamilies tests that proxies can be required to be, or not be, IPv6.
+func TestFilterAddressFamilies(t *testing.T) {
+	v4 := proxyFor(t, "http://1.1.1.1:80")
+	v6 := proxyFor(t, "http://[2001:db8::1]:80")
+	mapped := proxyFor(t, "http://[::ffff:1.1.1.1]:80")
+	hostname := proxyFor(t, "http://proxy.example.com:80")
+
+	assert.Equal(t, providers.FamilyIPv4, mapped.Family(), "IPv4-mapped addresses should count as IPv4")
+
+	tests := []struct {
+		filter prox.Filter
+		want   []bool
+	}{
+		{prox.FilterRequireIPv6(), []bool{false, true, false, false}},
+		{prox.FilterExcludeIPv6(), []bool{true, false, true, true}},
+		{prox.FilterAddressFamilies("IPv4"), []bool{true, false, true, false}},
+		{prox.FilterAddressFamilies(providers.FamilyHostname, providers.FamilyIPv6), []bool{false, true, false, true}},
+	}
+
+	for i, test := range tests {
+		for j, p := range []prox.Proxy{v4, v6, mapped, hostname} {
+			assert.Equal(t, test.want[j], test.filter(&p), "filter %d, proxy %v", i, p)
+		}
+	}
+
+	assert.Panics(t, func() { prox.FilterAddressFamilies("ipv5") })
+}
@@ -0,0 +1,128 @@
+package prox
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ollybritton/prox/providers"
+	"golang.org/x/net/proxy"
+)
+
+var (
+	parentMu         sync.RWMutex
+	connectionParent *url.URL
+)
+
+// SetParentProxy makes every provider fetch go through the parent proxy given, such as a corporate HTTP proxy
+// which is the only way out of a network. If connections is true, connections to the proxies found are made
+// through it too, unless a proxy has a parent of its own, including the connections made to check them. If parent
+// is nil, the defaults are restored.
+//
+// By default, provider fetches respect the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables, and
+// connections to proxies are direct. Use providers.SetParentProxy(nil) to make fetches ignore the environment.
+// Pools can use a parent proxy of their own with OptionParentProxy.
+func SetParentProxy(parent *url.URL, connections bool) {
+	if parent == nil {
+		providers.SetParentProxy(http.ProxyFromEnvironment)
+	} else {
+		providers.SetParentProxy(http.ProxyURL(parent))
+	}
+
+	parentMu.Lock()
+	defer parentMu.Unlock()
+
+	connectionParent = nil
+	if connections {
+		connectionParent = parent
+	}
+}
+
+// ConnectionDialer returns the dialer connections to proxies are made with: through the parent proxy given to
+// SetParentProxy if connections go through it, or directly.
+func ConnectionDialer() proxy.ContextDialer {
+	return parentDialer(globalConnectionParent())
+}
+
+// globalConnectionParent returns the parent proxy set by SetParentProxy for connections, or nil.
+func globalConnectionParent() *url.URL {
+	parentMu.RLock()
+	defer parentMu.RUnlock()
+
+	return connectionParent
+}
+
+// parentProxy returns the parent proxy connections to the proxy should go through, or nil if they are direct.
+func (p *Proxy) parentProxy() *url.URL {
+	if p.Parent != nil {
+		return p.Parent
+	}
+
+	return globalConnectionParent()
+}
+
+// dialer returns the dialer connections to the proxy are made with.
+func (p *Proxy) dialer() proxy.ContextDialer {
+	return parentDialer(p.parentProxy())
+}
+
+// parentDialer returns a dialer which connects through the parent proxy given as a one hop chain, or directly if
+// it is nil.
+func parentDialer(parent *url.URL) proxy.ContextDialer {
+	if parent == nil {
+		return &net.Dialer{}
+	}
+
+	return &Chain{proxies: []Proxy{{URL: parent}}}
+}
+
+// parentProber returns a copy of providers.DefaultProber which connects through the parent proxy given, or
+// providers.DefaultProber itself if it is nil.
+func parentProber(parent *url.URL) *providers.Prober {
+	if parent == nil {
+		return providers.DefaultProber
+	}
+
+	prober := *providers.DefaultProber
+	prober.Dialer = parentDialer(parent)
+
+	return &prober
+}
+
+// OptionParentProxy makes the pool's provider fetches go through the parent proxy given, rather than the one
+// set by SetParentProxy or the environment. If connections is true, the proxies returned by the pool have it as
+// their Parent, so connections to them go through it too, including those made by filters which check proxies.
+func OptionParentProxy(parent *url.URL, connections bool) Option {
+	return func(pool *ComplexPool) error {
+		pool.Config.ParentProxy = parent
+		pool.Config.ConnectThroughParent = connections
+		return nil
+	}
+}
+
+// fetchRequest creates the request the pool's providers are run with, which carries the pool's hints and parent
+// proxy, and a prober which detects schemes through the parent connections to the pool's proxies go through.
+func (pool *ComplexPool) fetchRequest(timeout time.Duration) providers.Request {
+	parent := pool.connectionParent()
+	if parent == nil {
+		parent = globalConnectionParent()
+	}
+
+	return providers.Request{
+		Timeout: timeout,
+		Hints:   pool.Config.Hints,
+		Parent:  pool.Config.ParentProxy,
+		Prober:  parentProber(parent),
+	}
+}
+
+// connectionParent returns the parent proxy the pool's proxies should have, or nil.
+func (pool *ComplexPool) connectionParent() *url.URL {
+	if !pool.Config.ConnectThroughParent {
+		return nil
+	}
+
+	return pool.Config.ParentProxy
+}
@@ -0,0 +1,159 @@
+package prox_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ollybritton/prox"
+	"github.com/ollybritton/prox/providers"
+	"github.com/stretchr/testify/assert"
+)
+
+// parentURL returns the URL of a HTTP proxy started with startHTTPProxy, with the credentials given.
+func parentURL(t *testing.T, server *httptest.Server, username, password string) *url.URL {
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u.User = url.UserPassword(username, password)
+	return u
+}
+
+// TestProxyParent tests that connections to a proxy with a parent go through the parent.
+func TestProxyParent(t *testing.T) {
+	target := startTarget(t)
+	parent := startHTTPProxy(t, "user", "pass")
+	addr := startSOCKS5Proxy(t, "", "")
+
+	p := proxyFor(t, "socks5://"+addr)
+	p.Parent = parentURL(t, parent, "user", "pass")
+
+	status, err := get(t, p, target.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNoContent, status)
+
+	p = proxyFor(t, "socks5://"+addr)
+	p.Parent = parentURL(t, parent, "user", "wrong")
+
+	_, err = get(t, p, target.URL)
+	assert.NotNil(t, err, "the connection should fail if the parent rejects it")
+}
+
+// TestSetParentProxyConnections tests that the global parent proxy is used for connections to proxies only if
+// asked to.
+func TestSetParentProxyConnections(t *testing.T) {
+	target := startTarget(t)
+	parent := parentURL(t, startHTTPProxy(t, "user", "pass"), "user", "wrong")
+	addr := startSOCKS5Proxy(t, "", "")
+
+	defer providers.SetParentProxy(http.ProxyFromEnvironment)
+	defer prox.SetParentProxy(nil, false)
+
+	prox.SetParentProxy(parent, false)
+	_, err := get(t, proxyFor(t, "socks5://"+addr), target.URL)
+	assert.Nil(t, err, "connections to proxies should be direct")
+
+	prox.SetParentProxy(parent, true)
+	_, err = get(t, proxyFor(t, "socks5://"+addr), target.URL)
+	assert.NotNil(t, err, "connections to proxies should go through the parent, which rejects them")
+
+	prober := providers.NewProber()
+	prober.Dialer = prox.ConnectionDialer()
+	assert.Empty(t, prober.Probe(context.Background(), addr).Protocols, "probes should go through the parent too")
+}
+
+// TestSetParentProxyNil tests that clearing the global parent proxy makes fetches respect the environment again.
+func TestSetParentProxyNil(t *testing.T) {
+	defer prox.SetParentProxy(nil, false)
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	want, _ := http.ProxyFromEnvironment(req)
+
+	prox.SetParentProxy(&url.URL{Scheme: "http", Host: "parent.invalid:8080"}, true)
+	prox.SetParentProxy(nil, false)
+
+	got, err := providers.ParentProxy(req)
+	assert.Nil(t, err)
+	assert.Equal(t, want, got)
+}
+
+// TestCheckCapabilitiesParent tests that proxies with a parent are probed and asked to relay UDP through it.
+func TestCheckCapabilitiesParent(t *testing.T) {
+	server := startHTTPProxy(t, "user", "pass")
+	echo := startUDPEcho(t)
+	addr := startSOCKS5UDPProxy(t, "", "")
+
+	tests := []struct {
+		password string
+		want     providers.Capability
+	}{
+		{"pass", providers.CapabilityUDP},
+		{"wrong", 0},
+	}
+
+	for _, test := range tests {
+		parent := parentURL(t, server, "user", test.password)
+
+		p := proxyFor(t, "socks5://"+addr)
+		p.Parent = parent
+		assert.Equal(t, test.want, p.CheckCapabilities(5*time.Second)&providers.CapabilityUDP, "password %v", test.password)
+	}
+
+	p := proxyFor(t, "socks5://"+addr)
+	p.Parent = parentURL(t, server, "user", "pass")
+
+	conn, err := p.ListenPacket(context.Background())
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	reply, _ := roundTrip(t, conn, echo, "hello")
+	assert.Equal(t, "hello", reply)
+}
+
+// TestOptionParentProxy tests that a pool fetches its providers through its parent proxy, and gives the proxies
+// it returns the parent if asked to.
+func TestOptionParentProxy(t *testing.T) {
+	var requested string
+
+	parent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.Host
+		fmt.Fprintln(w, "1.1.1.1:80")
+	}))
+	defer parent.Close()
+
+	u, _ := url.Parse(parent.URL)
+	provider := prox.Provider{"Scrape", providers.NewProxyScrape("http://provider.invalid/", nil)}
+
+	for _, connections := range []bool{false, true} {
+		pool := prox.NewComplexPool(
+			prox.UseProvider(provider),
+			prox.OptionQueryHints(providers.Hints{Schemes: []string{"http"}}),
+			prox.OptionParentProxy(u, connections),
+		)
+
+		if !assert.Nil(t, pool.Load()) {
+			continue
+		}
+
+		assert.Equal(t, "provider.invalid", requested)
+
+		p, err := pool.New()
+		if assert.Nil(t, err) {
+			assert.Equal(t, "http://1.1.1.1:80", p.URL.String())
+
+			if connections {
+				assert.Equal(t, u, p.Parent)
+			} else {
+				assert.Nil(t, p.Parent)
+			}
+		}
+	}
+}
@@ -2,8 +2,8 @@ package prox
 
 import (
 	"fmt"
-	"log"
 	"math/rand"
+	"net/url"
 	"sync"
 	"time"
 
@@ -23,6 +23,35 @@ type ComplexPool struct {
 		FallbackToBackupProviders bool
 		FallbackToCached          bool
 
+		// MaxGenerations is the amount of generations kept by the pool for Rollback and Diff. If it is zero,
+		// every generation is kept.
+		MaxGenerations int
+
+		// ExpireAfterLoads is the amount of consecutive loads a proxy can be missing from every provider before
+		// it is removed from the pool. If it is zero, proxies are never expired because of missed loads.
+		ExpireAfterLoads int
+
+		// MaxProxyAge is how long a proxy can go without being returned by a provider before it is removed
+		// from the pool. If it is zero, proxies are never expired because of their age.
+		MaxProxyAge time.Duration
+
+		// BlendTiers chooses proxies from every provider tier in proportion to their weight, rather than only
+		// using lower tiers once higher tiers are exhausted.
+		BlendTiers bool
+
+		// RestorePath is the location of a snapshot written by Save. If it is set, the pool will restore
+		// itself from the snapshot when every provider fails before anything has been loaded.
+		RestorePath string
+
+		// Hints are passed to every provider the pool fetches from, so that providers which support it only
+		// request matching proxies. See providers.Hints.
+		Hints providers.Hints
+
+		// ParentProxy is a proxy the pool's provider fetches go through. If ConnectThroughParent is true,
+		// connections to the pool's proxies go through it too. See OptionParentProxy.
+		ParentProxy          *url.URL
+		ConnectThroughParent bool
+
 		ReloadWhenEmpty bool
 	}
 
@@ -32,6 +61,86 @@ type ComplexPool struct {
 	CacheAvailable bool
 	CacheAll       *providers.Set
 	CacheUnused    *providers.Set
+
+	mu    sync.Mutex
+	stats map[string]*ProxyStats
+	loads []time.Time
+
+	lastReload ReloadReport
+	reviving   bool
+	lastStage  string
+	stages     []Stage
+	reports    []providers.Report
+
+	tiers    map[string]tier
+	grouped  *providerGroups
+	merges   int
+	breakers *Breakers
+
+	generations       []Generation
+	lastGeneration    int
+	currentGeneration int
+}
+
+// ProxyStats holds information about how a single proxy has been seen and used by a pool.
+type ProxyStats struct {
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	LastUsed  time.Time `json:"last_used,omitempty"`
+	TimesUsed int       `json:"times_used"`
+
+	// LastSeenLoad is the number of the load the proxy was last returned by a provider in, counting from 1.
+	LastSeenLoad int `json:"last_seen_load"`
+}
+
+// proxyKey returns the key used to identify a proxy across loads. Proxies are compared by URL rather
+// than by value, as two loads will never share the same *url.URL.
+func proxyKey(p providers.Proxy) string {
+	return p.URL.String()
+}
+
+// Stats returns the statistics recorded for the proxy given. The second return value is false if
+// the pool has never seen the proxy.
+func (pool *ComplexPool) Stats(p Proxy) (ProxyStats, bool) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	stats, ok := pool.stats[p.URL.String()]
+	if !ok {
+		return ProxyStats{}, false
+	}
+
+	return *stats, true
+}
+
+// LoadHistory returns the times at which the pool successfully loaded proxies, oldest first.
+func (pool *ComplexPool) LoadHistory() []time.Time {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	return append([]time.Time{}, pool.loads...)
+}
+
+// markUsed records that a proxy has been handed out by the pool.
+func (pool *ComplexPool) markUsed(p providers.Proxy) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	stats, ok := pool.stats[proxyKey(p)]
+	if !ok {
+		stats = &ProxyStats{FirstSeen: time.Now(), LastSeen: time.Now()}
+		pool.stats[proxyKey(p)] = stats
+	}
+
+	stats.LastUsed = time.Now()
+	stats.TimesUsed++
+}
+
+// updateCache stores a copy of the current proxies so that they can be reverted to with ApplyCache.
+func (pool *ComplexPool) updateCache() {
+	pool.CacheAvailable = true
+	pool.CacheAll = pool.All.Copy()
+	pool.CacheUnused = pool.Unused.Copy()
 }
 
 // SizeAll finds the amount of proxies that are currently loaded, used or unused.
@@ -50,76 +159,32 @@ func (pool *ComplexPool) SetTimeout(timeout time.Duration) {
 	pool.timeout = timeout
 }
 
-// Fetch fetches the proxies from it's internal providers and stores them.
+// Fetch fetches the proxies from it's internal providers and merges them into the pool. See LastReload for
+// the changes made by the fetch.
 func (pool *ComplexPool) Fetch() error {
 	logger.Debugf("prox (%p): attempting to fetch proxies from providers", pool)
-	collector := providers.NewSet()
+	pool.resetReports()
 
-	for _, provider := range pool.providers {
-
-		ps, err := provider.InternalProvider(collector, pool.timeout)
-		if err != nil {
-			log.Println(err)
-
-		}
-
-		for _, p := range ps {
-			pool.All.Add(p)
-
-			if !pool.Unused.In(p) {
-				pool.Unused.Add(p)
-			}
-		}
-
-	}
-
-	if len(collector.All()) == 0 {
+	err := pool.runStage(StagePrimary())
+	if err != nil {
 		logger.Errorf("prox (%p): no proxies could be loaded from providers", pool)
-		return fmt.Errorf("prox (%p): no proxies could be loaded from providers", pool)
+		return err
 	}
 
-	logger.Debugf("prox (%p): fetched %d proxies", pool, len(collector.All()))
-	logger.Debugf("prox (%p): updating cache with new proxies", pool)
-	pool.CacheAvailable = true
-	pool.CacheAll = pool.All
-	pool.CacheUnused = pool.Unused
-
 	return nil
 }
 
-// FetchFallback fetches the proxies from it's fallback providers and stores them.
+// FetchFallback fetches the proxies from it's fallback providers and merges them into the pool.
 func (pool *ComplexPool) FetchFallback() error {
 	logger.Debugf("prox (%p): attempting to fetch proxies from fallback providers", pool)
-	collector := providers.NewSet()
-	wg := &sync.WaitGroup{}
-
-	for _, provider := range pool.fallbackProviders {
-
-		ps, err := provider.InternalProvider(collector, pool.timeout)
-		if err != nil {
-			log.Println(err)
-
-		}
+	pool.resetReports()
 
-		for _, p := range ps {
-			pool.All.Add(p)
-
-			if !pool.Unused.In(p) {
-				pool.Unused.Add(p)
-			}
-		}
-
-	}
-
-	wg.Wait()
-
-	if len(collector.All()) == 0 {
+	err := pool.runStage(StageFallbackProviders())
+	if err != nil {
 		logger.Errorf("prox (%p): no proxies could be fetched from fallback providers", pool)
-		return fmt.Errorf("prox (%p): no proxies could be loaded from fallback providers", pool)
+		return err
 	}
 
-	logger.Debugf("prox (%p): fetched %d proxies", pool, len(collector.All()))
-
 	return nil
 }
 
@@ -138,50 +203,41 @@ func (pool *ComplexPool) ApplyCache() error {
 }
 
 // Load will fetch the proxies like a call to Fetch(), but, depending on options, it will fallback to a proxy
-// cache or use the fallback providers.
+// cache, the fallback providers or a snapshot on disk. See OptionFallbackPolicy to change the order these
+// are tried in.
 func (pool *ComplexPool) Load() error {
 	logger.Debugf("prox (%p): attempting to load new proxies", pool)
+	pool.resetReports()
 
-	err := pool.Fetch()
-	if err == nil {
-		pool.Filter(pool.filters...)
-		return nil
-	}
-
-	if pool.Config.FallbackToCached {
-		logger.Errorf("prox (%p): error occurred while fetching proxies: %v", pool, err)
+	var err error
 
-		err := pool.ApplyCache()
+	for _, stage := range pool.policy() {
+		err = pool.runStage(stage)
 		if err == nil {
-			pool.Filter(pool.filters...)
+			pool.finishLoad()
 			return nil
 		}
 
-		logger.Errorf("prov (%p): could not apply cache", pool)
+		logger.Errorf("prox (%p): error occurred while loading proxies: %v", pool, err)
 	}
 
-	if pool.Config.FallbackToBackupProviders {
-		logger.Errorf("prox (%p): error occurred while fetching proxies: %v", pool, err)
-		logger.Errorf("prox (%p): falling back to fallback providers", pool)
-
-		err = pool.FetchFallback()
-		if err == nil {
-			pool.Filter(pool.filters...)
-			return nil
-		}
-
-		logger.Errorf("prox (%p): error occurred while fetching fallback proxies: %v", pool, err)
+	if err == nil {
+		err = fmt.Errorf("prox (%p): no stages in fallback policy", pool)
 	}
 
 	return err
 }
 
+// finishLoad applies the pool's filters to newly loaded proxies and records the result as a new generation.
+func (pool *ComplexPool) finishLoad() {
+	pool.Filter(pool.filters...)
+	pool.recordGeneration(time.Now())
+}
+
 // Random fetches a random proxy. It doesn't care if the proxy has been used already.
 // It still marks a proxy as used.
 func (pool *ComplexPool) Random() (Proxy, error) {
-	length := pool.SizeAll()
-
-	if length == 0 {
+	if pool.SizeAll() == 0 {
 		if !pool.Config.ReloadWhenEmpty {
 			return Proxy{}, fmt.Errorf("prox (%p): cannot select random proxy, no proxies in pool", pool)
 		}
@@ -190,14 +246,17 @@ func (pool *ComplexPool) Random() (Proxy, error) {
 		if err != nil {
 			return Proxy{}, fmt.Errorf("prox (%p): cannot select random proxy, error occurred while reloading empty pool: %v", pool, err)
 		}
+	}
 
-		length = pool.SizeAll()
+	rawProxy, ok := pool.pick(pool.All, nil)
+	if !ok {
+		return Proxy{}, fmt.Errorf("prox (%p): cannot select random proxy, no proxies in pool", pool)
 	}
 
-	rawProxy := pool.All.Random()
 	pool.Unused.Remove(rawProxy)
+	pool.markUsed(rawProxy)
 
-	return *CastProxy(rawProxy), nil
+	return pool.cast(rawProxy), nil
 }
 
 // New fetches a new, unused proxy. Depending on options, it will attempt to reload the proxy
@@ -210,7 +269,7 @@ func (pool *ComplexPool) New() (Proxy, error) {
 			return Proxy{}, fmt.Errorf("prox (%p): cannot select proxy, no unused proxies left in pool", pool)
 		}
 
-		err := pool.Load()
+		err := pool.reloadExhausted()
 		if err != nil {
 			return Proxy{}, fmt.Errorf("prox (%p): cannot select unused proxy, error occurred while reloading pool: %v", pool, err)
 		}
@@ -221,10 +280,15 @@ func (pool *ComplexPool) New() (Proxy, error) {
 		}
 	}
 
-	rawProxy := pool.Unused.Random()
+	rawProxy, ok := pool.pick(pool.Unused, nil)
+	if !ok {
+		return Proxy{}, fmt.Errorf("prox (%p): cannot select proxy, no unused proxies left in pool", pool)
+	}
+
 	pool.Unused.Remove(rawProxy)
+	pool.markUsed(rawProxy)
 
-	return *CastProxy(rawProxy), nil
+	return pool.cast(rawProxy), nil
 }
 
 // NewFromCountries gets a new, unused proxy whose location is one of the countries specified.. Depending on options,
@@ -237,7 +301,7 @@ func (pool *ComplexPool) NewFromCountries(countries []string) (Proxy, error) {
 			return Proxy{}, fmt.Errorf("prox (%p): cannot select proxy, no unused proxies left in pool", pool)
 		}
 
-		err := pool.Load()
+		err := pool.reloadExhausted()
 		if err != nil {
 			return Proxy{}, fmt.Errorf("prox (%p): cannot select unused proxy, error occurred while reloading pool: %v", pool, err)
 		}
@@ -248,20 +312,63 @@ func (pool *ComplexPool) NewFromCountries(countries []string) (Proxy, error) {
 		}
 	}
 
-	rawProxy, err := pool.Unused.FromCountries(countries)
-	if err != nil {
-		return Proxy{}, fmt.Errorf("prox (%p): cannot get proxy from desired country: %v", pool, err)
+	rawProxy, ok := pool.pick(pool.Unused, func(p providers.Proxy) bool {
+		for _, c := range countries {
+			if p.Country == c {
+				return true
+			}
+		}
+
+		return false
+	})
+	if !ok {
+		return Proxy{}, fmt.Errorf("prox (%p): cannot get proxy from desired country: couldn't find proxy from country", pool)
+	}
+
+	pool.Unused.Remove(rawProxy)
+	pool.markUsed(rawProxy)
+
+	return pool.cast(rawProxy), nil
+}
+
+// NewWithCapabilities gets a new, unused proxy which is known to have every capability given. Depending on
+// options, it will attempt to reload the proxy pool if there are no proxies left inside the pool.
+func (pool *ComplexPool) NewWithCapabilities(capabilities providers.Capability) (Proxy, error) {
+	length := pool.SizeUnused()
+
+	if length == 0 {
+		if !pool.Config.ReloadWhenEmpty {
+			return Proxy{}, fmt.Errorf("prox (%p): cannot select proxy, no unused proxies left in pool", pool)
+		}
+
+		err := pool.reloadExhausted()
+		if err != nil {
+			return Proxy{}, fmt.Errorf("prox (%p): cannot select unused proxy, error occurred while reloading pool: %v", pool, err)
+		}
+
+		length = pool.SizeUnused()
+		if length == 0 {
+			return Proxy{}, fmt.Errorf("prox (%p): cannot select proxy, no unused proxies even after reload", pool)
+		}
+	}
+
+	rawProxy, ok := pool.pick(pool.Unused, func(p providers.Proxy) bool {
+		return p.Can(capabilities)
+	})
+	if !ok {
+		return Proxy{}, fmt.Errorf("prox (%p): cannot select proxy, no unused proxies with capabilities %v", pool, capabilities)
 	}
 
 	pool.Unused.Remove(rawProxy)
+	pool.markUsed(rawProxy)
 
-	return *CastProxy(rawProxy), nil
+	return pool.cast(rawProxy), nil
 }
 
 // Filter applies the filter to the proxies inside the pool.
 func (pool *ComplexPool) Filter(filters ...Filter) {
-	all := ApplyFilters(pool.All.List(), filters)
-	unused := ApplyFilters(pool.Unused.List(), filters)
+	all := applyFilters(pool.All.List(), filters, pool.connectionParent())
+	unused := applyFilters(pool.Unused.List(), filters, pool.connectionParent())
 
 	pool.All = providers.NewSet()
 	pool.Unused = providers.NewSet()
@@ -282,10 +389,14 @@ func NewComplexPool(opts ...Option) *ComplexPool {
 		All:     providers.NewSet(),
 		Unused:  providers.NewSet(),
 		timeout: 15 * time.Second,
+
+		stats: make(map[string]*ProxyStats),
+		tiers: make(map[string]tier),
 	}
 
 	// Default config options
 	pool.Config.FallbackToBackupProviders = true
+	pool.Config.MaxGenerations = 10
 
 	logger.Infof("prox: created new complex pool with id %p", pool)
 
@@ -343,7 +454,7 @@ func UseFallbackProviders(givenProviders ...Provider) Option {
 				logger.Panicf("prox (%p): invalid fallback provider '%v'", p, provider.Name)
 			}
 
-			p.providers = append(p.providers, provider)
+			p.fallbackProviders = append(p.fallbackProviders, provider)
 			providerNames = append(providerNames, provider.Name)
 		}
 
@@ -360,7 +471,8 @@ func UseFallbackProvider(provider Provider) Option {
 }
 
 // OptionReloadWhenEmpty sets the option to attempt to load new proxies into the pool if there are no proxies left in
-// the pool on a call to .Random() or .New()
+// the pool on a call to .Random() or .New(). Proxies which a reload like this finds again are made unused, so that
+// they can be handed out again.
 func OptionReloadWhenEmpty(setting bool) Option {
 	return func(pool *ComplexPool) error {
 		pool.Config.ReloadWhenEmpty = setting
@@ -376,6 +488,42 @@ func OptionFallbackToCached(setting bool) Option {
 	}
 }
 
+// OptionMaxGenerations sets the amount of generations the pool keeps for Rollback and Diff. By default, the
+// last 10 generations are kept. A value of zero keeps every generation.
+func OptionMaxGenerations(n int) Option {
+	return func(pool *ComplexPool) error {
+		pool.Config.MaxGenerations = n
+		return nil
+	}
+}
+
+// OptionExpireAfterLoads sets the option to remove proxies from the pool once they have been missing from every
+// provider for the given amount of consecutive loads.
+func OptionExpireAfterLoads(loads int) Option {
+	return func(pool *ComplexPool) error {
+		pool.Config.ExpireAfterLoads = loads
+		return nil
+	}
+}
+
+// OptionMaxProxyAge sets the option to remove proxies from the pool once they haven't been returned by any
+// provider for the given duration.
+func OptionMaxProxyAge(age time.Duration) Option {
+	return func(pool *ComplexPool) error {
+		pool.Config.MaxProxyAge = age
+		return nil
+	}
+}
+
+// OptionRestoreOnFailure sets the option to restore the pool from a snapshot written by Save if every provider
+// fails before the pool has loaded any proxies.
+func OptionRestoreOnFailure(path string) Option {
+	return func(pool *ComplexPool) error {
+		pool.Config.RestorePath = path
+		return nil
+	}
+}
+
 // OptionFallbackToBackupProviders sets the option to use the fallback providers if there is an error during loading.
 func OptionFallbackToBackupProviders(setting bool) Option {
 	return func(pool *ComplexPool) error {
@@ -384,6 +532,17 @@ func OptionFallbackToBackupProviders(setting bool) Option {
 	}
 }
 
+// OptionQueryHints sets the hints passed to the pool's providers, such as the countries or schemes wanted.
+// Providers which can filter on the server side will only request matching proxies, which is much quicker than
+// fetching everything and filtering it afterwards. Hints don't replace filters: providers which can't use them
+// still return every proxy, so the equivalent filters should be added too.
+func OptionQueryHints(hints providers.Hints) Option {
+	return func(pool *ComplexPool) error {
+		pool.Config.Hints = hints
+		return nil
+	}
+}
+
 // OptionAddFilters adds a list of filters to the pool
 func OptionAddFilters(filters ...Filter) Option {
 	return func(pool *ComplexPool) error {
@@ -31,10 +31,10 @@ func init() {
 
 	prox.InitLog(logger)
 
-	// add the dummy providers to the provider map
-	prox.Providers["DummyProvider"] = prox.Provider{"DummyProvider", providers.DummyProvider}
-	prox.Providers["DummyProviderEmpty"] = prox.Provider{"DummyProviderEmpty", providers.DummyProviderEmpty}
-	prox.Providers["DummyProviderError"] = prox.Provider{"DummyProviderError", providers.DummyProviderError}
+	// add the dummy providers to the default registry
+	for _, provider := range DummyProviders {
+		prox.DefaultRegistry.MustRegister(provider, prox.ProviderInfo{Description: "Dummy provider for testing"})
+	}
 }
 
 // TestComplexPoolCreation tests that the function NewComplexPool works.
@@ -0,0 +1,162 @@
+package prox
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ollybritton/prox/providers"
+)
+
+// Generation is an immutable record of the proxies that were in a ComplexPool straight after a successful load.
+type Generation struct {
+	ID       int
+	LoadedAt time.Time
+
+	proxies []providers.Proxy
+}
+
+// Proxies returns the proxies recorded in the generation.
+func (g Generation) Proxies() []providers.Proxy {
+	return append([]providers.Proxy{}, g.proxies...)
+}
+
+// Size returns the amount of proxies recorded in the generation.
+func (g Generation) Size() int {
+	return len(g.proxies)
+}
+
+// GenerationDiff describes how the proxies changed between two generations.
+type GenerationDiff struct {
+	From int
+	To   int
+
+	Added   []providers.Proxy
+	Removed []providers.Proxy
+}
+
+// recordGeneration stores the current proxies in the pool as a new generation, discarding the oldest
+// generations if there are more than Config.MaxGenerations.
+func (pool *ComplexPool) recordGeneration(now time.Time) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	pool.lastGeneration++
+	pool.currentGeneration = pool.lastGeneration
+
+	pool.generations = append(pool.generations, Generation{
+		ID:       pool.lastGeneration,
+		LoadedAt: now,
+		proxies:  pool.All.List(),
+	})
+
+	if max := pool.Config.MaxGenerations; max > 0 && len(pool.generations) > max {
+		pool.generations = pool.generations[len(pool.generations)-max:]
+	}
+
+	logger.Debugf("prox (%p): recorded generation %d with %d proxies", pool, pool.lastGeneration, pool.All.Length())
+}
+
+// Generations returns the generations currently kept by the pool, oldest first.
+func (pool *ComplexPool) Generations() []Generation {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	return append([]Generation{}, pool.generations...)
+}
+
+// Generation returns the ID of the generation the pool is currently using. It is zero if the pool has
+// never been loaded.
+func (pool *ComplexPool) Generation() int {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	return pool.currentGeneration
+}
+
+// findGeneration finds a generation by its ID. The caller must hold pool.mu.
+func (pool *ComplexPool) findGeneration(id int) (Generation, bool) {
+	for _, g := range pool.generations {
+		if g.ID == id {
+			return g, true
+		}
+	}
+
+	return Generation{}, false
+}
+
+// Rollback reverts the pool to the proxies recorded n generations before the newest one, so Rollback(1)
+// reverts to the load before last. Proxies which have been used since are not marked as unused again.
+func (pool *ComplexPool) Rollback(n int) error {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if n < 0 || n >= len(pool.generations) {
+		return fmt.Errorf("prox (%p): cannot roll back %d generations, only %d available", pool, n, len(pool.generations))
+	}
+
+	g := pool.generations[len(pool.generations)-1-n]
+
+	all := providers.NewSet()
+	unused := providers.NewSet()
+
+	for _, p := range g.proxies {
+		all.Add(p)
+
+		if stats, ok := pool.stats[proxyKey(p)]; !ok || stats.TimesUsed == 0 {
+			unused.Add(p)
+		}
+	}
+
+	pool.All = all
+	pool.Unused = unused
+	pool.currentGeneration = g.ID
+
+	logger.Debugf("prox (%p): rolled back to generation %d", pool, g.ID)
+
+	return nil
+}
+
+// Diff returns the proxies that were added and removed between the generations with the IDs given.
+func (pool *ComplexPool) Diff(from, to int) (GenerationDiff, error) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	a, ok := pool.findGeneration(from)
+	if !ok {
+		return GenerationDiff{}, fmt.Errorf("prox (%p): no generation with id %d", pool, from)
+	}
+
+	b, ok := pool.findGeneration(to)
+	if !ok {
+		return GenerationDiff{}, fmt.Errorf("prox (%p): no generation with id %d", pool, to)
+	}
+
+	return GenerationDiff{
+		From:    from,
+		To:      to,
+		Added:   proxyDifference(b.proxies, a.proxies),
+		Removed: proxyDifference(a.proxies, b.proxies),
+	}, nil
+}
+
+// proxyDifference returns the proxies in a which are not in b, sorted by URL.
+func proxyDifference(a, b []providers.Proxy) []providers.Proxy {
+	in := make(map[string]bool, len(b))
+	for _, p := range b {
+		in[proxyKey(p)] = true
+	}
+
+	result := []providers.Proxy{}
+	for _, p := range a {
+		if !in[proxyKey(p)] {
+			result = append(result, p)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return proxyKey(result[i]) < proxyKey(result[j])
+	})
+
+	return result
+}
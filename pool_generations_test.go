@@ -0,0 +1,113 @@
+package prox_test
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/ollybritton/prox"
+	"github.com/ollybritton/prox/providers"
+	"github.com/stretchr/testify/assert"
+)
+
+// sequenceProvider returns a provider which returns the next list of addresses each time it is called,
+// repeating the last list once it runs out.
+func sequenceProvider(name string, loads ...[]string) prox.Provider {
+	call := 0
+
+	return prox.Provider{Name: name, InternalProvider: func(set *providers.Set, req providers.Request) ([]providers.Proxy, error) {
+		addrs := loads[len(loads)-1]
+		if call < len(loads) {
+			addrs = loads[call]
+		}
+		call++
+
+		ps := []providers.Proxy{}
+		for _, addr := range addrs {
+			u, err := url.Parse(addr)
+			if err != nil {
+				return nil, err
+			}
+
+			p := providers.Proxy{URL: u, Provider: name, Country: "GB"}
+			set.Add(p)
+			ps = append(ps, p)
+		}
+
+		if len(ps) == 0 {
+			return ps, fmt.Errorf("providers (%v): no proxies could be gathered", name)
+		}
+
+		return ps, nil
+	}}
+}
+
+// TestComplexPoolGenerations tests that every load is recorded as a generation that can be compared and rolled
+// back to.
+func TestComplexPoolGenerations(t *testing.T) {
+	pool := prox.NewComplexPool(prox.UseProvider(sequenceProvider(
+		"Sequence",
+		[]string{"http://1.1.1.1:80", "http://2.2.2.2:80"},
+		[]string{"http://2.2.2.2:80", "http://3.3.3.3:80"},
+	)))
+
+	assert.Equal(t, 0, pool.Generation(), "unloaded pool should not have a generation")
+
+	assert.Nil(t, pool.Load())
+	assert.Nil(t, pool.Load())
+
+	generations := pool.Generations()
+	assert.Len(t, generations, 2)
+	assert.Equal(t, 2, pool.Generation())
+
+	diff, err := pool.Diff(generations[0].ID, generations[1].ID)
+	assert.Nil(t, err)
+	assert.Len(t, diff.Added, 1, "one proxy should have been added between generations")
+	assert.Equal(t, "http://3.3.3.3:80", diff.Added[0].URL.String())
+
+	_, err = pool.Diff(generations[0].ID, 100)
+	assert.NotNil(t, err, "diffing against an unknown generation should cause an error")
+
+	assert.Nil(t, pool.Rollback(1))
+	assert.Equal(t, generations[0].Size(), pool.SizeAll(), "rolling back should restore the older proxies")
+	assert.Equal(t, generations[0].ID, pool.Generation())
+
+	assert.NotNil(t, pool.Rollback(2), "rolling back further than the kept generations should cause an error")
+}
+
+// TestComplexPoolGenerationsImmutable tests that using proxies does not change a recorded generation.
+func TestComplexPoolGenerationsImmutable(t *testing.T) {
+	pool := prox.NewComplexPool(prox.UseProvider(DummyProvider))
+	assert.Nil(t, pool.Load())
+
+	size := pool.Generations()[0].Size()
+
+	_, err := pool.New()
+	assert.Nil(t, err)
+
+	pool.Unused = providers.NewSet()
+	pool.All = providers.NewSet()
+
+	assert.Equal(t, size, pool.Generations()[0].Size(), "changing the pool should not change a generation")
+
+	assert.Nil(t, pool.Rollback(0))
+	assert.Equal(t, size, pool.SizeAll())
+	assert.Equal(t, size-1, pool.SizeUnused(), "used proxies should stay used after a rollback")
+}
+
+// TestComplexPoolMaxGenerations tests that only the newest generations are kept.
+func TestComplexPoolMaxGenerations(t *testing.T) {
+	pool := prox.NewComplexPool(
+		prox.UseProvider(DummyProvider),
+		prox.OptionMaxGenerations(2),
+	)
+
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, pool.Load())
+	}
+
+	generations := pool.Generations()
+	assert.Len(t, generations, 2)
+	assert.Equal(t, 4, generations[0].ID)
+	assert.Equal(t, 5, generations[1].ID)
+}
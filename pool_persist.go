@@ -0,0 +1,193 @@
+package prox
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ollybritton/prox/providers"
+	"github.com/pkg/errors"
+)
+
+// snapshotVersion is the version of the snapshot format written by Save. Snapshots with a newer version
+// than this are refused by Restore.
+const snapshotVersion = 1
+
+// poolSnapshot is the on-disk representation of a ComplexPool.
+type poolSnapshot struct {
+	Version int             `json:"version"`
+	SavedAt time.Time       `json:"saved_at"`
+	Loads   []time.Time     `json:"loads"`
+	Proxies []proxySnapshot `json:"proxies"`
+}
+
+// proxySnapshot is the on-disk representation of a single proxy inside a pool.
+type proxySnapshot struct {
+	URL          string               `json:"url"`
+	Provider     string               `json:"provider"`
+	Country      string               `json:"country"`
+	Capabilities providers.Capability `json:"capabilities,omitempty"`
+	Used         bool                 `json:"used"`
+	Stats        *ProxyStats          `json:"stats,omitempty"`
+}
+
+// SaveTo writes the state of the pool to w as JSON. This includes every proxy, whether it has been used,
+// the statistics recorded for it and the times the pool was loaded.
+//
+// Unlike when proxies are printed, the credentials of authenticated proxies are kept so that they still work
+// once restored, so snapshots should be stored as carefully as the credentials themselves.
+func (pool *ComplexPool) SaveTo(w io.Writer) error {
+	snapshot := poolSnapshot{
+		Version: snapshotVersion,
+		SavedAt: time.Now(),
+		Loads:   pool.LoadHistory(),
+		Proxies: []proxySnapshot{},
+	}
+
+	pool.mu.Lock()
+	for _, p := range pool.All.List() {
+		ps := proxySnapshot{
+			URL:          p.URL.String(),
+			Provider:     p.Provider,
+			Country:      p.Country,
+			Capabilities: p.Capabilities,
+			Used:         !pool.Unused.In(p),
+		}
+
+		if stats, ok := pool.stats[proxyKey(p)]; ok {
+			copied := *stats
+			ps.Stats = &copied
+		}
+
+		snapshot.Proxies = append(snapshot.Proxies, ps)
+	}
+	pool.mu.Unlock()
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	if err := encoder.Encode(snapshot); err != nil {
+		return errors.Wrap(err, "prox: cannot encode pool snapshot")
+	}
+
+	return nil
+}
+
+// Save writes the state of the pool to the file at path. The file is replaced atomically, so a crash
+// part way through will never leave a half-written snapshot behind.
+func (pool *ComplexPool) Save(path string) error {
+	logger.Debugf("prox (%p): saving pool snapshot to %v", pool, path)
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "prox: cannot create pool snapshot")
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := pool.SaveTo(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "prox: cannot write pool snapshot")
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return errors.Wrap(err, "prox: cannot write pool snapshot")
+	}
+
+	return nil
+}
+
+// RestoreFrom replaces the state of the pool with a snapshot read from r. The restored proxies also
+// become the pool's cache.
+func (pool *ComplexPool) RestoreFrom(r io.Reader) error {
+	snapshot, err := decodeSnapshot(r)
+	if err != nil {
+		return err
+	}
+
+	return pool.applySnapshot(snapshot)
+}
+
+// decodeSnapshot reads and validates a snapshot written by SaveTo.
+func decodeSnapshot(r io.Reader) (poolSnapshot, error) {
+	snapshot := poolSnapshot{}
+
+	if err := json.NewDecoder(r).Decode(&snapshot); err != nil {
+		return snapshot, errors.Wrap(err, "prox: cannot decode pool snapshot")
+	}
+
+	if snapshot.Version < 1 || snapshot.Version > snapshotVersion {
+		return snapshot, fmt.Errorf("prox: unsupported pool snapshot version %d", snapshot.Version)
+	}
+
+	return snapshot, nil
+}
+
+// readSnapshot reads and validates the snapshot stored in the file at path.
+func readSnapshot(path string) (poolSnapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return poolSnapshot{}, errors.Wrap(err, "prox: cannot open pool snapshot")
+	}
+	defer f.Close()
+
+	return decodeSnapshot(f)
+}
+
+// applySnapshot replaces the state of the pool with the snapshot given.
+func (pool *ComplexPool) applySnapshot(snapshot poolSnapshot) error {
+	all := providers.NewSet()
+	unused := providers.NewSet()
+	stats := make(map[string]*ProxyStats)
+
+	for _, ps := range snapshot.Proxies {
+		p, err := NewProxy(ps.URL, ps.Provider, ps.Country)
+		if err != nil {
+			return errors.Wrap(err, "prox: invalid proxy in pool snapshot")
+		}
+
+		raw := providers.Proxy{URL: p.URL, Provider: p.Provider, Country: p.Country, Capabilities: ps.Capabilities}
+
+		all.Add(raw)
+		if !ps.Used {
+			unused.Add(raw)
+		}
+
+		if ps.Stats != nil {
+			copied := *ps.Stats
+			stats[proxyKey(raw)] = &copied
+		}
+	}
+
+	pool.mu.Lock()
+	pool.stats = stats
+	pool.loads = snapshot.Loads
+	pool.mu.Unlock()
+
+	pool.All = all
+	pool.Unused = unused
+	pool.updateCache()
+
+	logger.Debugf("prox (%p): restored %d proxies from snapshot saved at %v", pool, all.Length(), snapshot.SavedAt)
+
+	return nil
+}
+
+// Restore replaces the state of the pool with the snapshot stored in the file at path.
+func (pool *ComplexPool) Restore(path string) error {
+	logger.Debugf("prox (%p): restoring pool snapshot from %v", pool, path)
+
+	snapshot, err := readSnapshot(path)
+	if err != nil {
+		return err
+	}
+
+	return pool.applySnapshot(snapshot)
+}
@@ -0,0 +1,91 @@
+package prox_test
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ollybritton/prox"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestComplexPoolSaveRestore tests that saving and restoring a pool keeps which proxies have been used and
+// the statistics recorded for them.
+func TestComplexPoolSaveRestore(t *testing.T) {
+	pool := prox.NewComplexPool(prox.UseProvider(DummyProvider))
+
+	err := pool.Load()
+	assert.Nil(t, err)
+
+	used, err := pool.New()
+	assert.Nil(t, err)
+
+	path := filepath.Join(t.TempDir(), "pool.json")
+	assert.Nil(t, pool.Save(path))
+
+	restored := prox.NewComplexPool()
+	assert.Nil(t, restored.Restore(path))
+
+	assert.Equal(t, pool.SizeAll(), restored.SizeAll(), "restored pool should have the same amount of proxies")
+	assert.Equal(t, pool.SizeUnused(), restored.SizeUnused(), "restored pool should have the same amount of unused proxies")
+	assert.Len(t, restored.LoadHistory(), 1, "restored pool should keep its load history")
+
+	stats, ok := restored.Stats(used)
+	assert.True(t, ok, "restored pool should have stats for a used proxy")
+	assert.Equal(t, 1, stats.TimesUsed)
+	assert.True(t, restored.CacheAvailable, "restored proxies should be available as a cache")
+}
+
+// TestComplexPoolRestoreVersion tests that snapshots from an unknown version of the format are refused.
+func TestComplexPoolRestoreVersion(t *testing.T) {
+	pool := prox.NewComplexPool()
+
+	err := pool.RestoreFrom(strings.NewReader(`{"version": 999, "proxies": []}`))
+	assert.NotNil(t, err, "restoring a snapshot from a newer version should cause an error")
+
+	err = pool.RestoreFrom(strings.NewReader(`{"proxies": []}`))
+	assert.NotNil(t, err, "restoring a snapshot without a version should cause an error")
+}
+
+// TestComplexPoolRestoreOnFailure tests that a pool restores from disk if every provider fails at startup.
+func TestComplexPoolRestoreOnFailure(t *testing.T) {
+	initialPool := prox.NewComplexPool(prox.UseProvider(DummyProvider))
+	assert.Nil(t, initialPool.Load())
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, initialPool.SaveTo(buf))
+
+	path := filepath.Join(t.TempDir(), "pool.json")
+	assert.Nil(t, initialPool.Save(path))
+
+	pool := prox.NewComplexPool(
+		prox.UseProvider(DummyProviderError),
+		prox.OptionRestoreOnFailure(path),
+	)
+
+	err := pool.Load()
+	assert.Nil(t, err, "no error should occur if a snapshot is available to the pool")
+	assert.Equal(t, initialPool.SizeAll(), pool.SizeAll())
+
+	pool = prox.NewComplexPool(
+		prox.UseProvider(DummyProviderError),
+		prox.OptionRestoreOnFailure(filepath.Join(t.TempDir(), "missing.json")),
+	)
+
+	err = pool.Load()
+	assert.NotNil(t, err, "an error should occur if the snapshot does not exist")
+}
+
+// TestComplexPoolCacheIsSnapshot tests that using proxies does not change the cache.
+func TestComplexPoolCacheIsSnapshot(t *testing.T) {
+	pool := prox.NewComplexPool(prox.UseProvider(DummyProvider))
+	assert.Nil(t, pool.Load())
+
+	cached := pool.CacheUnused.Length()
+
+	_, err := pool.New()
+	assert.Nil(t, err)
+
+	assert.Equal(t, cached, pool.CacheUnused.Length(), "using a proxy should not change the cache")
+}
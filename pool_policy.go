@@ -0,0 +1,306 @@
+package prox
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ollybritton/prox/providers"
+)
+
+// StageFunc loads proxies for a stage of a fallback policy. It returns the amount of proxies it found and a
+// function which commits them to the pool. The commit function is only called if enough proxies were found.
+type StageFunc func(pool *ComplexPool, timeout time.Duration) (found int, commit func(), err error)
+
+// Stage is a single step of the fallback policy used by ComplexPool.Load. Stages are tried in order until one
+// of them finds at least MinProxies proxies.
+type Stage struct {
+	Name string
+
+	// Timeout is the timeout given to the stage. If it is zero, the pool's timeout is used.
+	Timeout time.Duration
+
+	// MinProxies is the amount of proxies the stage has to find to succeed. If it is zero, one proxy is enough.
+	MinProxies int
+
+	Func StageFunc
+}
+
+// NewStage creates a new stage from a StageFunc.
+func NewStage(name string, fn StageFunc) Stage {
+	return Stage{Name: name, Func: fn}
+}
+
+// WithTimeout returns a copy of the stage with a different timeout.
+func (s Stage) WithTimeout(timeout time.Duration) Stage {
+	s.Timeout = timeout
+	return s
+}
+
+// WithMinProxies returns a copy of the stage which needs at least n proxies to succeed.
+func (s Stage) WithMinProxies(n int) Stage {
+	s.MinProxies = n
+	return s
+}
+
+// StagePrimary creates a stage which fetches proxies from the providers added with UseProviders.
+func StagePrimary() Stage {
+	return NewStage("primary", func(pool *ComplexPool, timeout time.Duration) (int, func(), error) {
+		return fetchStage(pool, pool.providers, timeout)
+	})
+}
+
+// StageFallbackProviders creates a stage which fetches proxies from the providers added with UseFallbackProviders.
+func StageFallbackProviders() Stage {
+	return NewStage("fallback", func(pool *ComplexPool, timeout time.Duration) (int, func(), error) {
+		return fetchStage(pool, pool.fallbackProviders, timeout)
+	})
+}
+
+// StageProviders creates a stage which fetches proxies from the providers given.
+func StageProviders(givenProviders ...Provider) Stage {
+	names := []string{}
+	for _, provider := range givenProviders {
+		names = append(names, provider.Name)
+	}
+
+	return NewStage(
+		fmt.Sprintf("providers{%v}", strings.Join(names, "|")),
+		func(pool *ComplexPool, timeout time.Duration) (int, func(), error) {
+			return fetchStage(pool, givenProviders, timeout)
+		},
+	)
+}
+
+// StageStatic creates a stage which loads the proxies from the Static provider.
+func StageStatic() Stage {
+	stage := StageProviders(Static)
+	stage.Name = "static"
+
+	return stage
+}
+
+// StageCache creates a stage which reverts the pool to the proxies cached by the last successful fetch.
+func StageCache() Stage {
+	return NewStage("cache", func(pool *ComplexPool, timeout time.Duration) (int, func(), error) {
+		if !pool.CacheAvailable {
+			return 0, nil, fmt.Errorf("prox (%p): no cache to revert back to", pool)
+		}
+
+		return pool.CacheAll.Length(), func() { pool.ApplyCache() }, nil
+	})
+}
+
+// StageSnapshot creates a stage which restores the pool from a snapshot written by Save.
+func StageSnapshot(path string) Stage {
+	return NewStage("snapshot", func(pool *ComplexPool, timeout time.Duration) (int, func(), error) {
+		snapshot, err := readSnapshot(path)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		return len(snapshot.Proxies), func() { pool.applySnapshot(snapshot) }, nil
+	})
+}
+
+// fetchStage fetches proxies from the providers given. The providers are fetched at the same time and the stage
+// gives up on any still running once the timeout has passed, so a stage never takes much longer than its timeout
+// however many providers it has. Committing merges the proxies into the pool and updates the cache.
+func fetchStage(pool *ComplexPool, givenProviders []Provider, timeout time.Duration) (int, func(), error) {
+	now := time.Now()
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = make([][]providers.Proxy, len(givenProviders))
+		reports = make([]providers.Report, len(givenProviders))
+		done    = make([]bool, len(givenProviders))
+	)
+
+	for i, provider := range givenProviders {
+		var breaker *CircuitBreaker
+		if pool.breakers != nil {
+			breaker = pool.breakers.Get(provider.Name)
+		}
+
+		if breaker != nil && !breaker.Allow() {
+			logger.Debugf("prox (%p): skipping provider %v, circuit breaker is open until %v", pool, provider.Name, breaker.RetryAt())
+
+			reports[i] = providers.Report{
+				Provider: provider.Name,
+				Started:  time.Now(),
+				Skipped:  true,
+				Error:    "circuit breaker open",
+			}
+			done[i] = true
+
+			continue
+		}
+
+		wg.Add(1)
+
+		go func(i int, provider Provider, breaker *CircuitBreaker) {
+			defer wg.Done()
+
+			ps, report, err := providers.FetchRequest(provider.Name, provider.InternalProvider, pool.fetchRequest(timeout))
+			if err != nil {
+				log.Println(err)
+			}
+
+			// Proxies are given the name of the provider they were fetched from, which may be a wrapper such as
+			// Cached{Static}, so that tiers can be found for them. They're copied first since cached providers share
+			// their results between calls.
+			stamped := make([]providers.Proxy, len(ps))
+			for j, p := range ps {
+				p.Provider = provider.Name
+				stamped[j] = p
+			}
+			ps = stamped
+
+			if breaker != nil {
+				if err != nil {
+					breaker.Failure()
+				} else {
+					breaker.Success()
+				}
+			}
+
+			mu.Lock()
+			results[i], reports[i], done[i] = ps, report, true
+			mu.Unlock()
+		}(i, provider, breaker)
+	}
+
+	if waitTimeout(&wg, timeout) {
+		logger.Debugf("prox (%p): stage timed out after %v, ignoring providers which haven't finished", pool, timeout)
+	}
+
+	collector := providers.NewSet()
+
+	mu.Lock()
+	for i, provider := range givenProviders {
+		if !done[i] {
+			reports[i] = providers.Report{Provider: provider.Name, Started: now, Duration: timeout, Error: "stage timed out"}
+		}
+
+		for _, p := range results[i] {
+			collector.Add(p)
+		}
+	}
+
+	pool.mu.Lock()
+	pool.reports = append(pool.reports, reports...)
+	pool.mu.Unlock()
+	mu.Unlock()
+
+	ps := collector.List()
+	if len(ps) == 0 {
+		return 0, nil, fmt.Errorf("prox (%p): no proxies could be loaded from providers", pool)
+	}
+
+	logger.Debugf("prox (%p): fetched %d proxies", pool, len(ps))
+
+	return len(ps), func() {
+		pool.merge(ps, now)
+
+		logger.Debugf("prox (%p): updating cache with new proxies", pool)
+		pool.updateCache()
+	}, nil
+}
+
+// runStage runs a single stage, committing its proxies to the pool if it found enough of them.
+func (pool *ComplexPool) runStage(stage Stage) error {
+	timeout := stage.Timeout
+	if timeout == 0 {
+		timeout = pool.timeout
+	}
+
+	min := stage.MinProxies
+	if min < 1 {
+		min = 1
+	}
+
+	logger.Debugf("prox (%p): running load stage %v", pool, stage.Name)
+
+	found, commit, err := stage.Func(pool, timeout)
+	if err != nil {
+		return fmt.Errorf("prox (%p): stage %v failed: %v", pool, stage.Name, err)
+	}
+
+	if found < min {
+		return fmt.Errorf("prox (%p): stage %v found %d proxies, needs at least %d", pool, stage.Name, found, min)
+	}
+
+	commit()
+
+	pool.mu.Lock()
+	pool.lastStage = stage.Name
+	pool.mu.Unlock()
+
+	return nil
+}
+
+// policy returns the stages used by Load. If no policy has been set with OptionFallbackPolicy, it is built from
+// the pool's config.
+func (pool *ComplexPool) policy() []Stage {
+	if pool.stages != nil {
+		return pool.stages
+	}
+
+	stages := []Stage{StagePrimary()}
+
+	if pool.Config.FallbackToCached {
+		stages = append(stages, StageCache())
+	}
+
+	if pool.Config.FallbackToBackupProviders {
+		stages = append(stages, StageFallbackProviders())
+	}
+
+	if pool.Config.RestorePath != "" && len(pool.LoadHistory()) == 0 {
+		stages = append(stages, StageSnapshot(pool.Config.RestorePath))
+	}
+
+	return stages
+}
+
+// Reports returns a report for every provider fetched during the most recent load, in the order the providers were
+// fetched.
+func (pool *ComplexPool) Reports() []providers.Report {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	return append([]providers.Report{}, pool.reports...)
+}
+
+// resetReports clears the provider reports at the start of a load.
+func (pool *ComplexPool) resetReports() {
+	pool.mu.Lock()
+	pool.reports = nil
+	pool.mu.Unlock()
+}
+
+// LastStage returns the name of the stage that succeeded in the most recent load.
+func (pool *ComplexPool) LastStage() string {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	return pool.lastStage
+}
+
+// OptionFallbackPolicy sets the stages used by Load, in the order they are tried. Setting a policy overrides
+// FallbackToCached, FallbackToBackupProviders and RestorePath.
+func OptionFallbackPolicy(stages ...Stage) Option {
+	return func(pool *ComplexPool) error {
+		for _, stage := range stages {
+			if stage.Func == nil {
+				return fmt.Errorf("prox (%p): stage %v has no function", pool, stage.Name)
+			}
+		}
+
+		pool.stages = stages
+		return nil
+	}
+}
@@ -0,0 +1,201 @@
+package prox_test
+
+import (
+	"errors"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ollybritton/prox"
+	"github.com/ollybritton/prox/providers"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestComplexPoolPolicyOrder tests that the stages of a fallback policy are tried in order until one succeeds.
+func TestComplexPoolPolicyOrder(t *testing.T) {
+	pool := prox.NewComplexPool(
+		prox.UseProvider(DummyProviderError),
+		prox.OptionFallbackPolicy(
+			prox.StagePrimary(),
+			prox.StageCache(),
+			prox.StageProviders(DummyProviderEmpty),
+			prox.StageProviders(DummyProvider),
+		),
+	)
+
+	err := pool.Load()
+	assert.Nil(t, err, "no error should occur when a later stage succeeds")
+	assert.Equal(t, "providers{DummyProvider}", pool.LastStage())
+	assert.NotEqual(t, 0, pool.SizeAll())
+}
+
+// TestComplexPoolPolicyMinProxies tests that a stage which finds fewer proxies than its minimum fails without
+// changing the pool.
+func TestComplexPoolPolicyMinProxies(t *testing.T) {
+	pool := prox.NewComplexPool(
+		prox.OptionFallbackPolicy(
+			prox.StageProviders(sequenceProvider("Few", []string{"http://1.1.1.1:80"})).WithMinProxies(2),
+			prox.StageProviders(DummyProvider).WithMinProxies(10),
+		),
+	)
+
+	err := pool.Load()
+	assert.Nil(t, err)
+	assert.Equal(t, "providers{DummyProvider}", pool.LastStage())
+
+	for p := range pool.All.All() {
+		assert.NotEqual(t, "Few", p.Provider, "proxies from a failed stage should not be added to the pool")
+	}
+
+	pool = prox.NewComplexPool(
+		prox.OptionFallbackPolicy(
+			prox.StageProviders(DummyProvider).WithMinProxies(1000),
+		),
+	)
+
+	err = pool.Load()
+	assert.NotNil(t, err, "an error should occur when no stage finds enough proxies")
+	assert.Equal(t, 0, pool.SizeAll())
+}
+
+// TestComplexPoolPolicyTimeout tests that each stage is given its own timeout.
+func TestComplexPoolPolicyTimeout(t *testing.T) {
+	timeouts := []time.Duration{}
+
+	stage := func(name string) prox.Stage {
+		return prox.NewStage(name, func(pool *prox.ComplexPool, timeout time.Duration) (int, func(), error) {
+			timeouts = append(timeouts, timeout)
+			return 0, nil, errors.New("stage failed")
+		})
+	}
+
+	pool := prox.NewComplexPool(
+		prox.OptionFallbackPolicy(
+			stage("first").WithTimeout(time.Second),
+			stage("second"),
+		),
+	)
+	pool.SetTimeout(5 * time.Second)
+
+	err := pool.Load()
+	assert.NotNil(t, err)
+	assert.Equal(t, []time.Duration{time.Second, 5 * time.Second}, timeouts)
+}
+
+// slowProvider creates a provider which takes the time given to return a single proxy, ignoring its timeout.
+func slowProvider(name, addr string, delay time.Duration) prox.Provider {
+	provider := sequenceProvider(name, []string{addr})
+	internal := provider.InternalProvider
+
+	provider.InternalProvider = func(set *providers.Set, req providers.Request) ([]providers.Proxy, error) {
+		time.Sleep(delay)
+		return internal(set, req)
+	}
+
+	return provider
+}
+
+// TestComplexPoolStageDeadline tests that a stage with several providers finishes within its timeout, keeping the
+// proxies from providers which finished in time.
+func TestComplexPoolStageDeadline(t *testing.T) {
+	pool := prox.NewComplexPool(
+		prox.OptionFallbackPolicy(
+			prox.StageProviders(
+				slowProvider("SlowA", "http://1.1.1.1:80", 200*time.Millisecond),
+				slowProvider("SlowB", "http://2.2.2.2:80", 200*time.Millisecond),
+				slowProvider("Stuck", "http://3.3.3.3:80", 5*time.Second),
+			).WithTimeout(500 * time.Millisecond),
+		),
+	)
+
+	started := time.Now()
+	assert.Nil(t, pool.Load())
+	assert.True(t, time.Since(started) < time.Second, "the stage took %v", time.Since(started))
+	assert.Equal(t, 2, pool.SizeAll())
+
+	reports := pool.Reports()
+	if assert.Len(t, reports, 3) {
+		assert.Equal(t, "SlowA", reports[0].Provider)
+		assert.Empty(t, reports[0].Error)
+		assert.Equal(t, "Stuck", reports[2].Provider)
+		assert.Equal(t, "stage timed out", reports[2].Error)
+	}
+}
+
+// TestComplexPoolPolicySnapshot tests that a snapshot stage restores the pool from disk.
+func TestComplexPoolPolicySnapshot(t *testing.T) {
+	initialPool := prox.NewComplexPool(prox.UseProvider(DummyProvider))
+	assert.Nil(t, initialPool.Load())
+
+	path := filepath.Join(t.TempDir(), "pool.json")
+	assert.Nil(t, initialPool.Save(path))
+
+	pool := prox.NewComplexPool(
+		prox.UseProvider(DummyProviderEmpty),
+		prox.OptionFallbackPolicy(
+			prox.StagePrimary(),
+			prox.StageSnapshot(filepath.Join(t.TempDir(), "missing.json")),
+			prox.StageSnapshot(path),
+		),
+	)
+
+	assert.Nil(t, pool.Load())
+	assert.Equal(t, "snapshot", pool.LastStage())
+	assert.Equal(t, initialPool.SizeAll(), pool.SizeAll())
+}
+
+// TestComplexPoolFallbackProvidersNotPrimary tests that fallback providers are only used when the primary
+// providers fail.
+func TestComplexPoolFallbackProvidersNotPrimary(t *testing.T) {
+	pool := prox.NewComplexPool(
+		prox.UseProvider(sequenceProvider("Primary", []string{"http://1.1.1.1:80"})),
+		prox.UseFallbackProvider(DummyProvider),
+	)
+
+	assert.Nil(t, pool.Load())
+	assert.Equal(t, "primary", pool.LastStage())
+	assert.Equal(t, 1, pool.SizeAll(), "fallback providers should not be fetched when the primary providers work")
+}
+
+// TestComplexPoolReports tests that a report is available for each provider fetched during a load.
+func TestComplexPoolReports(t *testing.T) {
+	pool := prox.NewComplexPool(
+		prox.UseProviders(DummyProviderError, DummyProvider),
+	)
+
+	assert.Nil(t, pool.Load())
+
+	reports := pool.Reports()
+	assert.Len(t, reports, 2)
+
+	assert.Equal(t, "DummyProviderError", reports[0].Provider)
+	assert.NotEmpty(t, reports[0].Error)
+
+	assert.Equal(t, "DummyProvider", reports[1].Provider)
+	assert.Equal(t, pool.SizeAll(), reports[1].Unique)
+}
+
+// TestComplexPoolQueryHints tests that a pool passes its query hints and parent proxy to its providers, including
+// through provider wrappers.
+func TestComplexPoolQueryHints(t *testing.T) {
+	var got providers.Request
+
+	recorder := prox.Provider{Name: "Recorder", InternalProvider: func(set *providers.Set, req providers.Request) ([]providers.Proxy, error) {
+		got = req
+		return DummyProvider.InternalProvider(set, req)
+	}}
+
+	hints := providers.Hints{Countries: []string{"DE"}, Schemes: []string{"socks5"}}
+	parent, _ := url.Parse("http://parent.invalid:3128")
+
+	pool := prox.NewComplexPool(
+		prox.UseProvider(prox.FilteredProvider(recorder)),
+		prox.OptionQueryHints(hints),
+		prox.OptionParentProxy(parent, false),
+	)
+
+	assert.Nil(t, pool.Load())
+	assert.Equal(t, hints, got.Hints)
+	assert.Equal(t, parent, got.Parent)
+}
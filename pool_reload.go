@@ -0,0 +1,145 @@
+package prox
+
+import (
+	"time"
+
+	"github.com/ollybritton/prox/providers"
+)
+
+// ReloadReport describes how the proxies in a ComplexPool changed during a single load.
+type ReloadReport struct {
+	LoadedAt time.Time
+
+	// Added are proxies that weren't in the pool before the load.
+	Added []providers.Proxy
+
+	// Retained are proxies that were already in the pool and were returned by a provider again.
+	Retained []providers.Proxy
+
+	// Vanished are proxies that are still in the pool but weren't returned by any provider.
+	Vanished []providers.Proxy
+
+	// Expired are proxies that were removed from the pool because they haven't been returned by a provider
+	// recently enough, according to Config.ExpireAfterLoads and Config.MaxProxyAge.
+	Expired []providers.Proxy
+}
+
+// LastReload returns the changes made to the pool by the most recent successful fetch.
+func (pool *ComplexPool) LastReload() ReloadReport {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	return pool.lastReload
+}
+
+// reloadExhausted loads proxies because every proxy in the pool has been used. Unlike a normal load, proxies which
+// are returned by a provider again are made unused, since otherwise a load which mostly finds the same proxies
+// would leave the pool with nothing to hand out.
+func (pool *ComplexPool) reloadExhausted() error {
+	pool.mu.Lock()
+	pool.reviving = true
+	pool.mu.Unlock()
+
+	defer func() {
+		pool.mu.Lock()
+		pool.reviving = false
+		pool.mu.Unlock()
+	}()
+
+	return pool.Load()
+}
+
+// merge merges freshly fetched proxies into the pool. Proxies which are already in the pool keep their
+// stats and whether they have been used, unless the load was started by reloadExhausted, and proxies which
+// haven't been seen recently enough are expired.
+func (pool *ComplexPool) merge(fetched []providers.Proxy, now time.Time) ReloadReport {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	pool.loads = append(pool.loads, now)
+	load := len(pool.loads)
+	pool.merges++
+
+	report := ReloadReport{LoadedAt: now}
+
+	current := make(map[string]providers.Proxy)
+	for _, p := range pool.All.List() {
+		current[proxyKey(p)] = p
+	}
+
+	seen := make(map[string]bool)
+
+	for _, p := range fetched {
+		key := proxyKey(p)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if existing, ok := current[key]; ok {
+			if pool.reviving {
+				pool.Unused.Add(existing)
+			}
+
+			report.Retained = append(report.Retained, p)
+		} else {
+			pool.All.Add(p)
+			pool.Unused.Add(p)
+
+			report.Added = append(report.Added, p)
+		}
+
+		stats, ok := pool.stats[key]
+		if !ok {
+			stats = &ProxyStats{FirstSeen: now}
+			pool.stats[key] = stats
+		}
+
+		stats.LastSeen = now
+		stats.LastSeenLoad = load
+	}
+
+	for key, p := range current {
+		if seen[key] {
+			continue
+		}
+
+		if !pool.expired(pool.stats[key], load, now) {
+			report.Vanished = append(report.Vanished, p)
+			continue
+		}
+
+		pool.All.Remove(p)
+		pool.Unused.Remove(p)
+		delete(pool.stats, key)
+
+		report.Expired = append(report.Expired, p)
+	}
+
+	pool.lastReload = report
+
+	logger.Debugf(
+		"prox (%p): load %d added %d, retained %d, vanished %d and expired %d proxies",
+		pool, load, len(report.Added), len(report.Retained), len(report.Vanished), len(report.Expired),
+	)
+
+	return report
+}
+
+// expired decides whether a proxy that wasn't returned during the current load should be removed from the
+// pool. The caller must hold pool.mu.
+func (pool *ComplexPool) expired(stats *ProxyStats, load int, now time.Time) bool {
+	if stats == nil {
+		stats = &ProxyStats{}
+	}
+
+	if k := pool.Config.ExpireAfterLoads; k > 0 && load-stats.LastSeenLoad >= k {
+		return true
+	}
+
+	if age := pool.Config.MaxProxyAge; age > 0 && now.Sub(stats.LastSeen) > age {
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,113 @@
+package prox_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ollybritton/prox"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestComplexPoolDifferentialReload tests that reloading a pool keeps the history of proxies which are still
+// present and expires proxies which have been missing for too many loads.
+func TestComplexPoolDifferentialReload(t *testing.T) {
+	pool := prox.NewComplexPool(
+		prox.UseProvider(sequenceProvider(
+			"Sequence",
+			[]string{"http://1.1.1.1:80", "http://2.2.2.2:80"},
+			[]string{"http://2.2.2.2:80", "http://3.3.3.3:80"},
+			[]string{"http://3.3.3.3:80"},
+		)),
+		prox.OptionExpireAfterLoads(2),
+	)
+
+	assert.Nil(t, pool.Load())
+	assert.Len(t, pool.LastReload().Added, 2)
+
+	p, err := prox.NewProxy("http://2.2.2.2:80", "Sequence", "GB")
+	assert.Nil(t, err)
+
+	assert.Nil(t, pool.Load())
+	report := pool.LastReload()
+	assert.Len(t, report.Added, 1, "one new proxy should be added")
+	assert.Len(t, report.Retained, 1, "one proxy should still be present")
+	assert.Len(t, report.Vanished, 1, "one proxy should have vanished")
+	assert.Len(t, report.Expired, 0, "no proxies should have expired yet")
+	assert.Equal(t, 3, pool.SizeAll())
+
+	stats, ok := pool.Stats(p)
+	assert.True(t, ok)
+	assert.Equal(t, 2, stats.LastSeenLoad, "stats should be kept for proxies which are still present")
+
+	assert.Nil(t, pool.Load())
+	report = pool.LastReload()
+	assert.Len(t, report.Expired, 1, "a proxy missing for two loads should expire")
+	assert.Equal(t, "http://1.1.1.1:80", report.Expired[0].URL.String())
+	assert.Len(t, report.Vanished, 1)
+	assert.Equal(t, 2, pool.SizeAll())
+}
+
+// TestComplexPoolReloadKeepsUsed tests that a proxy which is returned again by a provider stays used.
+func TestComplexPoolReloadKeepsUsed(t *testing.T) {
+	pool := prox.NewComplexPool(prox.UseProvider(DummyProvider))
+
+	assert.Nil(t, pool.Load())
+	size := pool.SizeAll()
+
+	used, err := pool.New()
+	assert.Nil(t, err)
+
+	assert.Nil(t, pool.Load())
+	assert.Equal(t, size, pool.SizeAll(), "reloading the same proxies should not duplicate them")
+	assert.Equal(t, size-1, pool.SizeUnused(), "reloading should not mark a used proxy as unused")
+
+	stats, ok := pool.Stats(used)
+	assert.True(t, ok)
+	assert.Equal(t, 1, stats.TimesUsed)
+}
+
+// TestComplexPoolReloadWhenExhausted tests that when a pool reloads because every proxy has been used, proxies
+// which are found again can be handed out again.
+func TestComplexPoolReloadWhenExhausted(t *testing.T) {
+	pool := prox.NewComplexPool(
+		prox.UseProvider(sequenceProvider(
+			"Sequence",
+			[]string{"http://1.1.1.1:80", "http://2.2.2.2:80"},
+			[]string{"http://1.1.1.1:80", "http://2.2.2.2:80"},
+		)),
+		prox.OptionReloadWhenEmpty(true),
+	)
+
+	assert.Nil(t, pool.Load())
+
+	for i := 0; i < 2; i++ {
+		_, err := pool.New()
+		assert.Nil(t, err)
+	}
+
+	assert.Equal(t, 0, pool.SizeUnused())
+
+	_, err := pool.New()
+	assert.Nil(t, err, "proxies found again by the reload should be unused")
+	assert.Len(t, pool.LastReload().Retained, 2)
+	assert.Equal(t, 1, pool.SizeUnused())
+}
+
+// TestComplexPoolMaxProxyAge tests that proxies which haven't been seen within the maximum age are expired.
+func TestComplexPoolMaxProxyAge(t *testing.T) {
+	pool := prox.NewComplexPool(
+		prox.UseProvider(sequenceProvider(
+			"Sequence",
+			[]string{"http://1.1.1.1:80", "http://2.2.2.2:80"},
+			[]string{"http://2.2.2.2:80"},
+		)),
+		prox.OptionMaxProxyAge(time.Millisecond),
+	)
+
+	assert.Nil(t, pool.Load())
+	time.Sleep(5 * time.Millisecond)
+	assert.Nil(t, pool.Load())
+
+	assert.Len(t, pool.LastReload().Expired, 1)
+	assert.Equal(t, 1, pool.SizeAll())
+}
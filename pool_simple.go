@@ -47,7 +47,7 @@ func (pool *SimplePool) SetTimeout(timeout time.Duration) {
 // Load fetches the proxies from it's internal provider and stores them.
 func (pool *SimplePool) Load() error {
 	collector := providers.NewSet()
-	ps, err := pool.provider(collector, pool.timeout)
+	ps, err := pool.provider(collector, providers.Request{Timeout: pool.timeout, Prober: parentProber(globalConnectionParent())})
 	if err != nil {
 		return err
 	}
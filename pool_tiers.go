@@ -0,0 +1,215 @@
+package prox
+
+import (
+	"math/rand"
+	"sort"
+
+	"github.com/ollybritton/prox/providers"
+)
+
+// tier is the priority tier and weight assigned to a provider.
+type tier struct {
+	Tier   int
+	Weight float64
+}
+
+// defaultTier is used for proxies whose provider hasn't been given a tier.
+var defaultTier = tier{Tier: 0, Weight: 1}
+
+// tierOf returns the tier of the provider with the name given. Proxies are given the name of the provider the
+// pool fetched them from, so tiers are looked up by the Provider field of proxies.
+func (pool *ComplexPool) tierOf(provider string) tier {
+	if t, ok := pool.tiers[provider]; ok {
+		return t
+	}
+
+	return defaultTier
+}
+
+// cast converts a providers.Proxy into a prox.Proxy, including the tier information for its provider.
+func (pool *ComplexPool) cast(p providers.Proxy) Proxy {
+	proxy := *CastProxy(p)
+	proxy.Parent = pool.connectionParent()
+
+	t := pool.tierOf(p.Provider)
+	proxy.Tier = t.Tier
+	proxy.Weight = t.Weight
+
+	return proxy
+}
+
+// providerGroups holds the proxies in a pool grouped by provider. It is only built again once proxies have been
+// merged into the pool or its proxies have been replaced, so that picking a proxy doesn't have to regroup every
+// proxy in the pool.
+type providerGroups struct {
+	merges int
+	all    *providers.Set
+
+	names      []string
+	byProvider map[string][]providers.Proxy
+}
+
+// groups returns the proxies in the pool grouped by provider, building them again if proxies have been merged
+// into the pool, or the pool has switched generation or been filtered or restored, since they were last built.
+func (pool *ComplexPool) groups() *providerGroups {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if g := pool.grouped; g != nil && g.merges == pool.merges && g.all == pool.All {
+		return g
+	}
+
+	g := &providerGroups{
+		merges:     pool.merges,
+		all:        pool.All,
+		byProvider: make(map[string][]providers.Proxy),
+	}
+
+	for _, p := range pool.All.List() {
+		g.byProvider[p.Provider] = append(g.byProvider[p.Provider], p)
+	}
+
+	for name := range g.byProvider {
+		g.names = append(g.names, name)
+	}
+	sort.Strings(g.names)
+
+	pool.grouped = g
+
+	return g
+}
+
+// pick selects a proxy from the set which is accepted by the function given, or from any proxy in the set if
+// accept is nil. If no tiers have been configured, every proxy is equally likely. Otherwise, proxies from the
+// highest tier (lowest number) are chosen first, weighted by provider, unless Config.BlendTiers is set, in
+// which case proxies from every tier are blended in proportion to their weight.
+func (pool *ComplexPool) pick(set *providers.Set, accept func(providers.Proxy) bool) (providers.Proxy, bool) {
+	if len(pool.tiers) == 0 && accept == nil {
+		if set.Length() == 0 {
+			return providers.Proxy{}, false
+		}
+
+		return set.Random(), true
+	}
+
+	groups := pool.groups()
+	names := append([]string{}, groups.names...)
+
+	for len(names) != 0 {
+		candidates := names
+
+		if !pool.Config.BlendTiers {
+			best := pool.tierOf(names[0]).Tier
+			for _, name := range names {
+				if t := pool.tierOf(name).Tier; t < best {
+					best = t
+				}
+			}
+
+			candidates = []string{}
+			for _, name := range names {
+				if pool.tierOf(name).Tier == best {
+					candidates = append(candidates, name)
+				}
+			}
+		}
+
+		name := pickWeighted(candidates, func(name string) float64 {
+			return pool.tierOf(name).Weight
+		})
+
+		if p, ok := pickFrom(groups.byProvider[name], set, accept); ok {
+			return p, true
+		}
+
+		// Every proxy from the provider has been used or isn't accepted, so try the others.
+		for i := range names {
+			if names[i] == name {
+				names = append(names[:i], names[i+1:]...)
+				break
+			}
+		}
+	}
+
+	return providers.Proxy{}, false
+}
+
+// pickFrom returns a random proxy from the proxies given which is still in the set and accepted by the function
+// given. Proxies are checked from a random starting point, so picking is quick while most of them are available.
+func pickFrom(proxies []providers.Proxy, set *providers.Set, accept func(providers.Proxy) bool) (providers.Proxy, bool) {
+	if len(proxies) == 0 {
+		return providers.Proxy{}, false
+	}
+
+	start := rand.Intn(len(proxies))
+	for i := range proxies {
+		p := proxies[(start+i)%len(proxies)]
+		if set.In(p) && (accept == nil || accept(p)) {
+			return p, true
+		}
+	}
+
+	return providers.Proxy{}, false
+}
+
+// pickWeighted chooses one of the names given at random, in proportion to their weights. If every weight is
+// zero, each name is equally likely.
+func pickWeighted(names []string, weight func(string) float64) string {
+	total := 0.0
+	for _, name := range names {
+		if w := weight(name); w > 0 {
+			total += w
+		}
+	}
+
+	if total == 0 {
+		return names[rand.Intn(len(names))]
+	}
+
+	r := rand.Float64() * total
+	for _, name := range names {
+		w := weight(name)
+		if w <= 0 {
+			continue
+		}
+
+		if r < w {
+			return name
+		}
+		r -= w
+	}
+
+	return names[len(names)-1]
+}
+
+// UseProviderTier adds providers to the pool with the priority tier and weight given. Proxies from providers
+// in tier 0 are preferred over tier 1, and so on. Lower tiers are only used once higher tiers are exhausted,
+// unless OptionBlendTiers is set. Within a tier, providers are chosen in proportion to their weight. Providers
+// added with UseProviders are in tier 0 with a weight of 1.
+//
+// Tiers are matched to proxies by provider name: the pool sets the Provider of every proxy it fetches to the Name
+// of the provider it came from, such as "List{/etc/proxies}" or "Cached{Static}".
+func UseProviderTier(tierNumber int, weight float64, givenProviders ...Provider) Option {
+	return func(p *ComplexPool) error {
+		if err := UseProviders(givenProviders...)(p); err != nil {
+			return err
+		}
+
+		for _, provider := range givenProviders {
+			p.tiers[provider.Name] = tier{Tier: tierNumber, Weight: weight}
+		}
+
+		logger.Debugf("prox (%p): assigned tier %d with weight %v to %d providers", p, tierNumber, weight, len(givenProviders))
+
+		return nil
+	}
+}
+
+// OptionBlendTiers sets the option to choose proxies from every tier in proportion to the weights of their
+// providers, rather than exhausting higher tiers first.
+func OptionBlendTiers(setting bool) Option {
+	return func(pool *ComplexPool) error {
+		pool.Config.BlendTiers = setting
+		return nil
+	}
+}
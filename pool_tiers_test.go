@@ -0,0 +1,143 @@
+package prox_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ollybritton/prox"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestComplexPoolTiers tests that proxies from lower tiers are only used once higher tiers are exhausted.
+func TestComplexPoolTiers(t *testing.T) {
+	pool := prox.NewComplexPool(
+		prox.UseProviderTier(1, 1, sequenceProvider("Low", []string{"http://1.1.1.1:80", "http://2.2.2.2:80"})),
+		prox.UseProviderTier(0, 2, sequenceProvider("High", []string{"http://3.3.3.3:80", "http://4.4.4.4:80"})),
+	)
+
+	assert.Nil(t, pool.Load())
+
+	for i := 0; i < 2; i++ {
+		p, err := pool.New()
+		assert.Nil(t, err)
+		assert.Equal(t, "High", p.Provider, "proxies from the highest tier should be used first")
+		assert.Equal(t, 0, p.Tier)
+		assert.Equal(t, 2.0, p.Weight)
+	}
+
+	for i := 0; i < 2; i++ {
+		p, err := pool.New()
+		assert.Nil(t, err)
+		assert.Equal(t, "Low", p.Provider, "proxies from lower tiers should be used once higher tiers are exhausted")
+		assert.Equal(t, 1, p.Tier)
+	}
+}
+
+// TestComplexPoolBlendTiers tests that blending tiers chooses providers in proportion to their weight.
+func TestComplexPoolBlendTiers(t *testing.T) {
+	pool := prox.NewComplexPool(
+		prox.UseProviderTier(0, 3, sequenceProvider("Heavy", []string{"http://1.1.1.1:80", "http://2.2.2.2:80"})),
+		prox.UseProviderTier(1, 1, sequenceProvider("Light", []string{"http://3.3.3.3:80", "http://4.4.4.4:80"})),
+		prox.OptionBlendTiers(true),
+	)
+
+	assert.Nil(t, pool.Load())
+
+	heavy := 0
+	picks := 4000
+
+	for i := 0; i < picks; i++ {
+		p, err := pool.Random()
+		assert.Nil(t, err)
+
+		if p.Provider == "Heavy" {
+			heavy++
+		}
+	}
+
+	share := float64(heavy) / float64(picks)
+	assert.InDelta(t, 0.75, share, 0.05, "providers should be chosen in proportion to their weight")
+}
+
+// TestComplexPoolTiersCountries tests that NewFromCountries respects tiers.
+func TestComplexPoolTiersCountries(t *testing.T) {
+	pool := prox.NewComplexPool(
+		prox.UseProviderTier(1, 1, DummyProvider),
+		prox.UseProviderTier(0, 1, sequenceProvider("High", []string{"http://1.1.1.1:80"})),
+	)
+
+	assert.Nil(t, pool.Load())
+
+	p, err := pool.NewFromCountries([]string{"GB"})
+	assert.Nil(t, err)
+	assert.Equal(t, "High", p.Provider)
+
+	p, err = pool.NewFromCountries([]string{"DE"})
+	assert.Nil(t, err)
+	assert.Equal(t, "DummyProvider", p.Provider)
+
+	_, err = pool.NewFromCountries([]string{"GB"})
+	assert.NotNil(t, err, "an error should occur when no proxies from the country are left")
+}
+
+// TestComplexPoolTiersWrappedProviders tests that tiers apply to providers whose names differ from the name the
+// underlying provider gives its proxies, such as file lists and cached providers.
+func TestComplexPoolTiersWrappedProviders(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxies.txt")
+	if err := ioutil.WriteFile(path, []byte("http://1.1.1.1:80\nhttp://2.2.2.2:80\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	list := prox.FileList(path)
+	cached := prox.CachedProvider(sequenceProvider("High", []string{"http://3.3.3.3:80", "http://4.4.4.4:80"}), time.Minute)
+
+	pool := prox.NewComplexPool(
+		prox.UseProviderTier(1, 1, list),
+		prox.UseProviderTier(0, 1, cached),
+	)
+
+	assert.Nil(t, pool.Load())
+
+	for i := 0; i < 2; i++ {
+		p, err := pool.New()
+		assert.Nil(t, err)
+		assert.Equal(t, cached.Name, p.Provider, "proxies should be named after the provider they were fetched from")
+		assert.Equal(t, 0, p.Tier, "proxies from the highest tier should be used first")
+	}
+
+	for i := 0; i < 2; i++ {
+		p, err := pool.New()
+		assert.Nil(t, err)
+		assert.Equal(t, list.Name, p.Provider)
+		assert.Equal(t, 1, p.Tier)
+	}
+}
+
+// TestComplexPoolTiersFetch tests that proxies merged into the pool by Fetch can be picked straight away.
+func TestComplexPoolTiersFetch(t *testing.T) {
+	pool := prox.NewComplexPool(
+		prox.UseProviderTier(0, 1, sequenceProvider("Sequence", []string{"http://1.1.1.1:80"}, []string{"http://2.2.2.2:80"})),
+	)
+
+	assert.Nil(t, pool.Load())
+
+	p, err := pool.New()
+	assert.Nil(t, err)
+	assert.Equal(t, "http://1.1.1.1:80", p.URL.String())
+
+	assert.Nil(t, pool.Fetch())
+
+	p, err = pool.New()
+	if assert.Nil(t, err, "the fetched proxy should be picked") {
+		assert.Equal(t, "http://2.2.2.2:80", p.URL.String())
+	}
+
+	p, err = pool.Random()
+	assert.Nil(t, err)
+	assert.NotEmpty(t, p.URL.String())
+
+	_, err = pool.New()
+	assert.NotNil(t, err, "an error should occur once every proxy has been used")
+}
@@ -29,8 +29,23 @@ var GetProxyList = Provider{"GetProxyList", providers.GetProxyList}
 // Static defines the 'Static' provider.
 var Static = Provider{"Static", providers.Static}
 
+// FileList creates a provider which reads proxies from a file, or every file in a directory. See
+// providers.NewFileList for the formats it accepts.
+func FileList(path string, opts ...providers.ListOption) Provider {
+	return Provider{fmt.Sprintf("List{%v}", path), providers.NewFileList(path, opts...)}
+}
+
+// SSHConfigFile creates a provider which gives a ssh:// proxy for every host named in an ssh_config file, such as
+// ~/.ssh/config. See providers.NewSSHConfigFile.
+func SSHConfigFile(path string) Provider {
+	return Provider{fmt.Sprintf("SSHConfig{%v}", path), providers.NewSSHConfigFile(path)}
+}
+
 // Providers is a global variable which allows translation between the names of providers
 // and the provider functions themselves.
+//
+// Deprecated: Use DefaultRegistry, Register and Lookup instead. Providers is only used to seed DefaultRegistry
+// when the package is initialised, so changes made to it afterwards have no effect.
 var Providers = map[string]Provider{
 	"FreeProxyLists": FreeProxyLists,
 	"ProxyScrape":    ProxyScrape,
@@ -38,26 +53,87 @@ var Providers = map[string]Provider{
 	"Static":         Static,
 }
 
+// DefinedProvider creates a provider from a declarative definition. See providers.Definition.
+func DefinedProvider(def providers.Definition) (Provider, error) {
+	internal, err := providers.NewDefinedProvider(def, nil)
+	if err != nil {
+		return Provider{}, err
+	}
+
+	return Provider{def.Name, internal}, nil
+}
+
+// RegisterDefinitions creates providers from the definitions given and adds them to the default registry, so
+// that they can be used by name. Nothing is registered if any definition is invalid or has the name of an
+// existing provider.
+func RegisterDefinitions(defs ...providers.Definition) error {
+	defined := []Provider{}
+	infos := []ProviderInfo{}
+
+	for _, def := range defs {
+		provider, err := DefinedProvider(def)
+		if err != nil {
+			return err
+		}
+
+		defined = append(defined, provider)
+		infos = append(infos, ProviderInfo{Description: def.Description, NeedsNetwork: true})
+	}
+
+	return DefaultRegistry.registerAll(defined, infos)
+}
+
+// CommandProvider creates a provider which runs an external program. See providers.Command.
+func CommandProvider(c providers.Command) (Provider, error) {
+	internal, err := providers.NewCommandProvider(c)
+	if err != nil {
+		return Provider{}, err
+	}
+
+	return Provider{c.Name, internal}, nil
+}
+
+// RegisterCommands creates providers from the commands given and adds them to the default registry, so that
+// they can be used by name. Nothing is registered if any command is invalid or has the name of an existing
+// provider.
+func RegisterCommands(cmds ...providers.Command) error {
+	defined := []Provider{}
+	infos := []ProviderInfo{}
+
+	for _, c := range cmds {
+		provider, err := CommandProvider(c)
+		if err != nil {
+			return err
+		}
+
+		defined = append(defined, provider)
+		infos = append(infos, ProviderInfo{Description: c.Description})
+	}
+
+	return DefaultRegistry.registerAll(defined, infos)
+}
+
 // panicValidProvider will panic if the string specified does not correspond to a valid provider.
-func panicValidProvider(providerName string) {
-	if Providers[providerName].InternalProvider == nil {
+func panicValidProvider(providerName string) Provider {
+	provider, ok := Lookup(providerName)
+	if !ok {
 		panic(errors.New("invalid provider type " + providerName))
 	}
+
+	return provider
 }
 
-// GetProvider gets the provider by name.
+// GetProvider gets the provider by name from the default registry.
 func GetProvider(providerName string) Provider {
-	panicValidProvider(providerName)
-	return Providers[providerName]
+	return panicValidProvider(providerName)
 }
 
-// GetProviders gets multiple providers by name.
+// GetProviders gets multiple providers by name from the default registry.
 func GetProviders(providerNames ...string) []Provider {
 	results := []Provider{}
 
 	for _, providerName := range providerNames {
-		panicValidProvider(providerName)
-		results = append(results, Providers[providerName])
+		results = append(results, panicValidProvider(providerName))
 	}
 
 	return results
@@ -74,19 +150,19 @@ func MultiProvider(givenProviders ...Provider) Provider {
 
 	name := fmt.Sprintf("Multi{%v}", strings.Join(names, "|"))
 
-	return Provider{name, func(proxies *providers.Set, timeout time.Duration) ([]providers.Proxy, error) {
+	return Provider{name, func(proxies *providers.Set, req providers.Request) ([]providers.Proxy, error) {
 		var wg = &sync.WaitGroup{}
 
 		for _, provider := range givenProviders {
 			wg.Add(1)
 
 			go func(provider providers.Provider) {
-				provider(proxies, timeout)
+				provider(proxies, req)
 				wg.Done()
 			}(provider.InternalProvider)
 		}
 
-		waitTimeout(wg, timeout)
+		waitTimeout(wg, req.Timeout)
 
 		ps := proxies.List()
 		if len(ps) == 0 {
@@ -100,10 +176,10 @@ func MultiProvider(givenProviders ...Provider) Provider {
 // FreezeProvider will gather proxies from the provider given one last time
 // and then use those instead of new ones.
 func FreezeProvider(providerName string, timeout time.Duration) providers.Provider {
-	panicValidProvider(providerName)
+	provider := panicValidProvider(providerName)
 
-	ps, err := Providers[providerName].InternalProvider(providers.NewSet(), timeout)
-	return func(proxies *providers.Set, timeout time.Duration) ([]providers.Proxy, error) {
+	ps, err := provider.InternalProvider(providers.NewSet(), providers.NewRequest(timeout))
+	return func(proxies *providers.Set, req providers.Request) ([]providers.Proxy, error) {
 		if err != nil {
 			return []providers.Proxy{}, err
 		}
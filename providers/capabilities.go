@@ -0,0 +1,160 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Capability is a set of things a proxy can do, which its scheme alone doesn't say. Capabilities are combined
+// with |, so a single Capability can hold several of them.
+type Capability uint
+
+// The capabilities a proxy can have.
+const (
+	// CapabilityConnect means the proxy can tunnel arbitrary TCP connections, such as HTTPS requests. Every SOCKS
+	// proxy can, but HTTP proxies have to support the CONNECT method.
+	CapabilityConnect Capability = 1 << iota
+
+	// CapabilityTLS means the connection to the proxy itself uses TLS, as with https:// proxies.
+	CapabilityTLS
+
+	// CapabilityRemoteDNS means the proxy resolves hostnames itself, so that they aren't looked up locally.
+	CapabilityRemoteDNS
+
+	// CapabilityUDP means the proxy can relay UDP, using the SOCKS5 UDP ASSOCIATE command.
+	CapabilityUDP
+
+	// CapabilityAuth means the proxy requires credentials.
+	CapabilityAuth
+)
+
+// capabilityNames are the names of each capability, in the order they are printed.
+var capabilityNames = []struct {
+	capability Capability
+	name       string
+}{
+	{CapabilityConnect, "connect"},
+	{CapabilityTLS, "tls"},
+	{CapabilityRemoteDNS, "remote_dns"},
+	{CapabilityUDP, "udp"},
+	{CapabilityAuth, "auth"},
+}
+
+// Has returns true if c includes every capability in other.
+func (c Capability) Has(other Capability) bool {
+	return c&other == other
+}
+
+// Names returns the names of the capabilities in c, such as "connect" and "udp".
+func (c Capability) Names() []string {
+	names := []string{}
+
+	for _, cn := range capabilityNames {
+		if c.Has(cn.capability) {
+			names = append(names, cn.name)
+		}
+	}
+
+	return names
+}
+
+// String returns the names of the capabilities in c separated by commas, or "none".
+func (c Capability) String() string {
+	if c == 0 {
+		return "none"
+	}
+
+	return strings.Join(c.Names(), ",")
+}
+
+// MarshalJSON encodes the capabilities as a list of names.
+func (c Capability) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.Names())
+}
+
+// UnmarshalJSON decodes a list of capability names.
+func (c *Capability) UnmarshalJSON(data []byte) error {
+	names := []string{}
+	if err := json.Unmarshal(data, &names); err != nil {
+		return err
+	}
+
+	parsed, err := ParseCapabilities(names...)
+	if err != nil {
+		return err
+	}
+
+	*c = parsed
+	return nil
+}
+
+// ParseCapabilities returns the capabilities with the names given, ignoring case. Each name can also be a list
+// of names separated by commas.
+func ParseCapabilities(names ...string) (Capability, error) {
+	var c Capability
+
+	for _, list := range names {
+		for _, name := range strings.Split(list, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+
+			found := false
+			for _, cn := range capabilityNames {
+				if cn.name == name {
+					c |= cn.capability
+					found = true
+				}
+			}
+
+			if !found {
+				return 0, fmt.Errorf("providers: unknown capability %q", name)
+			}
+		}
+	}
+
+	return c, nil
+}
+
+// ImpliedCapabilities returns the capabilities a proxy with the URL given has just because of its scheme and
+// credentials: SOCKS proxies can tunnel connections, socks4a:// and socks5h:// proxies resolve hostnames,
+// https:// proxies use TLS, ssh:// proxies tunnel connections, resolve hostnames and always require credentials,
+// and proxies with credentials in their URL require them.
+func ImpliedCapabilities(u *url.URL) Capability {
+	if u == nil {
+		return 0
+	}
+
+	var c Capability
+
+	switch u.Scheme {
+	case "https":
+		c |= CapabilityTLS
+	case "socks4", "socks5":
+		c |= CapabilityConnect
+	case "socks4a", "socks5h":
+		c |= CapabilityConnect | CapabilityRemoteDNS
+	case "ssh":
+		c |= CapabilityConnect | CapabilityRemoteDNS | CapabilityAuth
+	}
+
+	if u.User != nil {
+		c |= CapabilityAuth
+	}
+
+	return c
+}
+
+// AllCapabilities returns the capabilities the proxy is known to have, either because they were declared by its
+// provider or found by a check, or because they are implied by its URL.
+func (p Proxy) AllCapabilities() Capability {
+	return p.Capabilities | ImpliedCapabilities(p.URL)
+}
+
+// Can returns true if the proxy is known to have every capability given.
+func (p Proxy) Can(c Capability) bool {
+	return p.AllCapabilities().Has(c)
+}
@@ -0,0 +1,328 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// StatusError is returned by Engine.Get when a request completes with a status code other than 2xx.
+type StatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("providers: request to %v returned status %d", e.URL, e.StatusCode)
+}
+
+// Engine is the HTTP client shared by the built-in providers. It limits the amount of requests made at once,
+// both in total and to a single host, rate limits requests to each host, retries failed requests with
+// exponential backoff and always closes response bodies.
+//
+// The zero value is usable, but has no limits. Use NewEngine for sensible defaults. An engine's settings
+// shouldn't be changed once it has been used.
+type Engine struct {
+	// Client is the client used to make requests. If it is nil, a client like http.DefaultClient is used which
+	// chooses a parent proxy for each request with ParentProxy, as does the client created by NewEngine.
+	Client *http.Client
+
+	// UserAgent is sent with every request, if it is set.
+	UserAgent string
+
+	// MaxConcurrency is the maximum amount of requests in flight at once. Zero means no limit.
+	MaxConcurrency int
+
+	// MaxPerHost is the maximum amount of requests in flight to a single host at once. Zero means no limit.
+	MaxPerHost int
+
+	// Rate is the amount of requests per second allowed to a single host, and Burst is the amount of requests
+	// which can be made at once before the rate applies. A Rate of zero means no limit.
+	Rate  float64
+	Burst int
+
+	// Retries is the amount of times a request is retried if it fails with a network error, a 5xx or a 429.
+	// Backoff is how long to wait before the first retry, doubling for each retry after.
+	Retries int
+	Backoff time.Duration
+
+	once    sync.Once
+	global  chan struct{}
+	mu      sync.Mutex
+	hosts   map[string]chan struct{}
+	buckets map[string]*tokenBucket
+}
+
+// NewEngine creates an engine with the default limits used by the built-in providers.
+func NewEngine() *Engine {
+	return &Engine{
+		Client:         &http.Client{Transport: newTransport()},
+		UserAgent:      "prox (+https://github.com/ollybritton/prox)",
+		MaxConcurrency: 64,
+		MaxPerHost:     8,
+		Rate:           20,
+		Burst:          10,
+		Retries:        2,
+		Backoff:        500 * time.Millisecond,
+	}
+}
+
+// DefaultEngine is the engine used by the built-in providers.
+var DefaultEngine = NewEngine()
+
+// engineOrDefault returns the engine given, or DefaultEngine if it is nil.
+func engineOrDefault(e *Engine) *Engine {
+	if e == nil {
+		return DefaultEngine
+	}
+
+	return e
+}
+
+func (e *Engine) init() {
+	e.once.Do(func() {
+		if e.MaxConcurrency > 0 {
+			e.global = make(chan struct{}, e.MaxConcurrency)
+		}
+
+		e.hosts = make(map[string]chan struct{})
+		e.buckets = make(map[string]*tokenBucket)
+	})
+}
+
+// host returns the per-host semaphore and token bucket for the host given. Either may be nil if there is
+// no limit.
+func (e *Engine) host(host string) (chan struct{}, *tokenBucket) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	sem, ok := e.hosts[host]
+	if !ok && e.MaxPerHost > 0 {
+		sem = make(chan struct{}, e.MaxPerHost)
+		e.hosts[host] = sem
+	}
+
+	bucket, ok := e.buckets[host]
+	if !ok && e.Rate > 0 {
+		bucket = newTokenBucket(e.Rate, e.Burst)
+		e.buckets[host] = bucket
+	}
+
+	return sem, bucket
+}
+
+// acquire takes a slot from the semaphore, returning a function to release it. A nil semaphore is unlimited.
+func acquire(ctx context.Context, sem chan struct{}) (func(), error) {
+	if sem == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Get requests the URL given and returns the body of the response. Requests which fail with a network error,
+// a 5xx or a 429 are retried. Responses with any other non-2xx status return a *StatusError.
+func (e *Engine) Get(ctx context.Context, rawurl string) ([]byte, error) {
+	e.init()
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, errors.Wrap(err, "providers: invalid url")
+	}
+
+	sem, bucket := e.host(u.Host)
+	backoff := e.Backoff
+
+	for attempt := 0; ; attempt++ {
+		body, retry, err := e.get(ctx, rawurl, sem, bucket)
+		if err == nil || !retry || attempt >= e.Retries || ctx.Err() != nil {
+			return body, err
+		}
+
+		logger.Debugf("providers: retrying request to %v in %v after error: %v", rawurl, backoff, err)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, err
+		}
+
+		backoff *= 2
+	}
+}
+
+// get makes a single request, returning whether it is worth retrying if it fails.
+func (e *Engine) get(ctx context.Context, rawurl string, sem chan struct{}, bucket *tokenBucket) ([]byte, bool, error) {
+	releaseGlobal, err := acquire(ctx, e.global)
+	if err != nil {
+		return nil, false, err
+	}
+	defer releaseGlobal()
+
+	releaseHost, err := acquire(ctx, sem)
+	if err != nil {
+		return nil, false, err
+	}
+	defer releaseHost()
+
+	if bucket != nil {
+		if err := bucket.wait(ctx); err != nil {
+			return nil, false, err
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, rawurl, nil)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "providers: cannot create request")
+	}
+	req = req.WithContext(ctx)
+
+	if e.UserAgent != "" {
+		req.Header.Set("User-Agent", e.UserAgent)
+	}
+
+	client := e.Client
+	if client == nil {
+		client = defaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, true, errors.Wrap(err, "providers: cannot read response body")
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return body, retry, &StatusError{URL: rawurl, StatusCode: resp.StatusCode}
+	}
+
+	return body, false, nil
+}
+
+// GetAll requests every URL given concurrently, within the engine's limits, and calls fn with the result of
+// each request as it completes. Calls to fn may happen concurrently. Requests which fail because the context
+// is done are dropped. GetAll returns once every request has finished.
+func (e *Engine) GetAll(ctx context.Context, urls []string, fn func(url string, body []byte, err error)) {
+	e.getEach(ctx, urls, func(u string, body []byte, err error) bool {
+		fn(u, body, err)
+		return true
+	})
+}
+
+// getEach is like GetAll, but stops starting new requests once fn returns false. Requests already in flight
+// still finish, and fn is still called with their results. Only MaxConcurrency goroutines are started, rather
+// than one for every URL, since providers can request thousands.
+func (e *Engine) getEach(ctx context.Context, urls []string, fn func(url string, body []byte, err error) bool) {
+	workers := len(urls)
+	if e.MaxConcurrency > 0 && e.MaxConcurrency < workers {
+		workers = e.MaxConcurrency
+	}
+
+	var stopped int32
+
+	jobs := make(chan string)
+	wg := &sync.WaitGroup{}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for u := range jobs {
+				if atomic.LoadInt32(&stopped) == 1 {
+					continue
+				}
+
+				body, err := e.Get(ctx, u)
+				if err != nil && ctx.Err() != nil {
+					continue
+				}
+
+				if !fn(u, body, err) {
+					atomic.StoreInt32(&stopped, 1)
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, u := range urls {
+		if atomic.LoadInt32(&stopped) == 1 {
+			break
+		}
+
+		select {
+		case jobs <- u:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+
+	close(jobs)
+	wg.Wait()
+}
+
+// tokenBucket is a simple token bucket rate limiter.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// wait blocks until a token is available or the context is done.
+func (b *tokenBucket) wait(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+
+		now := time.Now()
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return nil
+		}
+
+		delay := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
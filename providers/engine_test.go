@@ -0,0 +1,105 @@
+package providers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ollybritton/prox/providers"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestEngineRetries tests that requests which fail with a 5xx are retried, and that other non-2xx statuses
+// are returned as a StatusError without being retried.
+func TestEngineRetries(t *testing.T) {
+	var requests int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/flaky":
+			if atomic.AddInt32(&requests, 1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+
+			w.Write([]byte("ok"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	engine := &providers.Engine{Retries: 2, Backoff: time.Millisecond}
+
+	body, err := engine.Get(context.Background(), server.URL+"/flaky")
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", string(body))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+
+	_, err = engine.Get(context.Background(), server.URL+"/missing")
+	if assert.IsType(t, &providers.StatusError{}, err) {
+		assert.Equal(t, http.StatusNotFound, err.(*providers.StatusError).StatusCode)
+	}
+}
+
+// TestEngineLimits tests that the engine never has more requests in flight to a host than MaxPerHost, and that
+// it sends its user agent.
+func TestEngineLimits(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+
+		w.Write([]byte(r.UserAgent()))
+	}))
+	defer server.Close()
+
+	engine := &providers.Engine{UserAgent: "prox-test", MaxPerHost: 2}
+
+	urls := make([]string, 10)
+	for i := range urls {
+		urls[i] = server.URL
+	}
+
+	var completed int32
+	engine.GetAll(context.Background(), urls, func(url string, body []byte, err error) {
+		assert.Nil(t, err)
+		assert.Equal(t, "prox-test", string(body))
+		atomic.AddInt32(&completed, 1)
+	})
+
+	assert.Equal(t, int32(10), completed)
+	assert.LessOrEqual(t, maxInFlight, 2)
+}
+
+// TestEngineRateLimit tests that requests to a host are spread out according to the engine's rate.
+func TestEngineRateLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	engine := &providers.Engine{Rate: 20, Burst: 1}
+	started := time.Now()
+
+	for i := 0; i < 5; i++ {
+		_, err := engine.Get(context.Background(), server.URL)
+		assert.Nil(t, err)
+	}
+
+	assert.True(t, time.Since(started) >= 150*time.Millisecond, "requests should be rate limited")
+}
@@ -0,0 +1,56 @@
+package providers
+
+import (
+	"time"
+)
+
+// The anonymity levels a proxy can have, from least to most anonymous.
+const (
+	AnonymityTransparent = "transparent"
+	AnonymityAnonymous   = "anonymous"
+	AnonymityElite       = "elite"
+)
+
+// Hints describe the proxies the caller is interested in, so that providers which can filter on the server side
+// only request those. Hints are only an optimisation: providers which can't use them ignore them, and a provider
+// may still return proxies which don't match, so they should be used alongside filters rather than instead of
+// them. The zero value asks for every proxy.
+type Hints struct {
+	// Countries are the ISO Alpha-2 codes of the countries wanted, such as "DE".
+	Countries []string
+
+	// Schemes are the schemes of the proxies wanted, such as "http" or "socks5". Asking for "socks4a" or "socks5h"
+	// is the same as asking for "socks4" or "socks5", since they are the same proxies.
+	Schemes []string
+
+	// Anonymity is the anonymity level wanted, one of AnonymityTransparent, AnonymityAnonymous or AnonymityElite.
+	Anonymity string
+
+	// SSL asks for proxies which support HTTPS.
+	SSL bool
+}
+
+// Empty returns true if the hints don't narrow down the proxies at all.
+func (h Hints) Empty() bool {
+	return len(h.Countries) == 0 && len(h.Schemes) == 0 && h.Anonymity == "" && !h.SSL
+}
+
+// WantsScheme returns true if proxies with the scheme given are wanted. Schemes are compared by BaseScheme.
+func (h Hints) WantsScheme(scheme string) bool {
+	if len(h.Schemes) == 0 {
+		return true
+	}
+
+	for _, s := range h.Schemes {
+		if BaseScheme(s) == BaseScheme(scheme) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FetchWithHints is like Fetch, but passes the hints given to the provider.
+func FetchWithHints(name string, provider Provider, timeout time.Duration, hints Hints) ([]Proxy, Report, error) {
+	return fetch(name, provider, Request{Timeout: timeout, Hints: hints}, NewSet())
+}
@@ -0,0 +1,64 @@
+package providers
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+var (
+	parentMu    sync.RWMutex
+	parentProxy = http.ProxyFromEnvironment
+)
+
+// SetParentProxy sets the function which chooses the parent proxy requests made by engines go through, like
+// http.Transport.Proxy. It is http.ProxyFromEnvironment by default, so HTTP_PROXY, HTTPS_PROXY and NO_PROXY are
+// respected. If it is nil, requests connect directly. The parent proxy of a Request overrides it.
+//
+// It only applies to engines whose client uses the transport created by NewEngine.
+func SetParentProxy(proxy func(*http.Request) (*url.URL, error)) {
+	parentMu.Lock()
+	defer parentMu.Unlock()
+
+	parentProxy = proxy
+}
+
+// ParentProxy returns the parent proxy the request given should go through: the one in the request's context if
+// there is one, otherwise the one chosen by the function given to SetParentProxy. A nil URL means the request
+// should connect directly.
+func ParentProxy(req *http.Request) (*url.URL, error) {
+	if parent, ok := req.Context().Value(parentContextKey{}).(*url.URL); ok {
+		return parent, nil
+	}
+
+	parentMu.RLock()
+	proxy := parentProxy
+	parentMu.RUnlock()
+
+	if proxy == nil {
+		return nil, nil
+	}
+
+	return proxy(req)
+}
+
+type parentContextKey struct{}
+
+// WithParentProxy returns a context which makes requests made with it by an engine go through the parent proxy
+// given, rather than the one chosen by SetParentProxy.
+func WithParentProxy(ctx context.Context, parent *url.URL) context.Context {
+	return context.WithValue(ctx, parentContextKey{}, parent)
+}
+
+// defaultClient is used by engines without a client of their own.
+var defaultClient = &http.Client{Transport: newTransport()}
+
+// newTransport creates the transport used by engines created with NewEngine, which is like
+// http.DefaultTransport but chooses a parent proxy with ParentProxy.
+func newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = ParentProxy
+
+	return transport
+}
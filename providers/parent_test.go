@@ -0,0 +1,87 @@
+package providers_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ollybritton/prox/providers"
+	"github.com/stretchr/testify/assert"
+)
+
+// startParentProxy starts a HTTP proxy which answers every request itself with a list of proxies, and records
+// the hosts requested through it.
+func startParentProxy(t *testing.T) (*url.URL, func() []string) {
+	var (
+		mu    sync.Mutex
+		hosts []string
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hosts = append(hosts, r.URL.Host)
+		mu.Unlock()
+
+		fmt.Fprintln(w, "1.1.1.1:80\n2.2.2.2:80")
+	}))
+	t.Cleanup(server.Close)
+
+	u, _ := url.Parse(server.URL)
+
+	return u, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return append([]string{}, hosts...)
+	}
+}
+
+// TestEngineParentProxy tests that requests go through the parent proxy in their context, or the one set with
+// SetParentProxy.
+func TestEngineParentProxy(t *testing.T) {
+	parent, hosts := startParentProxy(t)
+
+	direct := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "direct")
+	}))
+	defer direct.Close()
+
+	engine := providers.NewEngine()
+	engine.Retries = 0
+
+	body, err := engine.Get(providers.WithParentProxy(context.Background(), parent), "http://provider.invalid/list")
+	assert.Nil(t, err)
+	assert.Contains(t, string(body), "1.1.1.1:80")
+
+	providers.SetParentProxy(http.ProxyURL(parent))
+	defer providers.SetParentProxy(http.ProxyFromEnvironment)
+
+	_, err = fixtureEngine().Get(context.Background(), direct.URL)
+	assert.Nil(t, err)
+
+	body, err = engine.Get(providers.WithParentProxy(context.Background(), nil), direct.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, "direct", string(body), "a nil parent in the context should connect directly")
+
+	assert.Equal(t, []string{"provider.invalid", direct.Listener.Addr().String()}, hosts())
+}
+
+// TestRequestParentProxy tests that providers make their requests through the parent proxy of the request they
+// are given.
+func TestRequestParentProxy(t *testing.T) {
+	parent, hosts := startParentProxy(t)
+
+	req := providers.Request{Timeout: 5 * time.Second, Hints: providers.Hints{Schemes: []string{"http"}}, Parent: parent}
+
+	provider := providers.NewProxyScrape("http://provider.invalid/", fixtureEngine())
+	ps, _, err := providers.FetchRequest("ProxyScrape", provider, req)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"http://1.1.1.1:80", "http://2.2.2.2:80"}, proxyURLs(ps))
+
+	assert.Equal(t, []string{"provider.invalid"}, hosts())
+}
@@ -0,0 +1,402 @@
+package providers
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// SchemeDetect can be given as the default scheme of a list, definition or command provider. Proxies found
+// without a scheme are then probed with DefaultProber and given the scheme of the protocol they speak, and those
+// which don't speak any are rejected with RejectUnknownProtocol.
+const SchemeDetect = "detect"
+
+// probeSchemes are the protocols a Prober tries, in order of preference.
+var probeSchemes = []string{"socks5", "http", "socks4"}
+
+// Prober finds out which protocols a proxy speaks by trying the SOCKS5 handshake, a SOCKS4 request and a HTTP
+// CONNECT request in parallel, each over its own connection.
+//
+// A protocol counts as spoken if the proxy answers in that protocol, even if it refuses the request, so proxies
+// which need credentials are detected too. HTTP proxies have to accept the CONNECT request or ask for
+// credentials, so that ordinary web servers aren't mistaken for proxies.
+type Prober struct {
+	// Dialer is used to connect to proxies, for example through a parent proxy. If it is nil, connections are
+	// made directly.
+	Dialer proxy.ContextDialer
+
+	// Timeout is how long to wait for a proxy to answer, for all of the protocols together.
+	Timeout time.Duration
+
+	// Target is the address, an IPv4 address and a port, asked for in SOCKS4 and CONNECT requests.
+	Target string
+
+	// Concurrency is the maximum amount of proxies probed at once by ProbeAll and by providers. Zero means
+	// no limit.
+	Concurrency int
+}
+
+// NewProber creates a prober with the defaults used by the providers.
+func NewProber() *Prober {
+	return &Prober{
+		Timeout:     5 * time.Second,
+		Target:      "1.1.1.1:443",
+		Concurrency: 64,
+	}
+}
+
+// DefaultProber is the prober used by providers to detect the scheme of proxies found without one.
+var DefaultProber = NewProber()
+
+// ProbeResult is the outcome of probing a single address.
+type ProbeResult struct {
+	Address string
+
+	// Protocols are the schemes of the protocols the address speaks, in order of preference.
+	Protocols []string
+
+	// Errors says why each of the other protocols wasn't detected.
+	Errors map[string]error
+
+	// Capabilities are the capabilities found while probing each protocol, such as CapabilityAuth if the proxy
+	// asked for credentials. They don't include those implied by the scheme.
+	Capabilities map[string]Capability
+}
+
+// Speaks returns true if the address speaks the protocol with the scheme given.
+func (r ProbeResult) Speaks(scheme string) bool {
+	for _, protocol := range r.Protocols {
+		if protocol == strings.ToLower(scheme) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Scheme returns the scheme to use for the address: the preferred protocol it speaks, or "" if it doesn't speak
+// any. SOCKS5 is preferred, then HTTP, then SOCKS4.
+func (r ProbeResult) Scheme() string {
+	if len(r.Protocols) == 0 {
+		return ""
+	}
+
+	return r.Protocols[0]
+}
+
+// Probe finds out which protocols the proxy at address, in the form host:port, speaks.
+func (p *Prober) Probe(ctx context.Context, address string) ProbeResult {
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+
+	checks := map[string]func(net.Conn) (Capability, error){
+		"socks5": p.checkSOCKS5,
+		"http":   p.checkHTTP,
+		"socks4": p.checkSOCKS4,
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs = make(map[string]error, len(checks))
+		caps = make(map[string]Capability, len(checks))
+	)
+
+	for scheme, check := range checks {
+		wg.Add(1)
+
+		go func(scheme string, check func(net.Conn) (Capability, error)) {
+			defer wg.Done()
+
+			c, err := p.try(ctx, address, check)
+
+			mu.Lock()
+			errs[scheme] = err
+			caps[scheme] = c
+			mu.Unlock()
+		}(scheme, check)
+	}
+
+	wg.Wait()
+
+	result := newProbeResult(address)
+	for _, scheme := range probeSchemes {
+		if errs[scheme] == nil {
+			result.Protocols = append(result.Protocols, scheme)
+			result.Capabilities[scheme] = caps[scheme]
+		} else {
+			result.Errors[scheme] = errs[scheme]
+		}
+	}
+
+	return result
+}
+
+func newProbeResult(address string) ProbeResult {
+	return ProbeResult{
+		Address:      address,
+		Protocols:    []string{},
+		Errors:       map[string]error{},
+		Capabilities: map[string]Capability{},
+	}
+}
+
+// ProbeAll probes every address given, at most Concurrency at a time, and returns the results in the same order.
+func (p *Prober) ProbeAll(ctx context.Context, addresses []string) []ProbeResult {
+	results := make([]ProbeResult, len(addresses))
+	sem := p.semaphore()
+	wg := sync.WaitGroup{}
+
+	for i, address := range addresses {
+		wg.Add(1)
+
+		go func(i int, address string) {
+			defer wg.Done()
+
+			release, err := acquire(ctx, sem)
+			if err != nil {
+				results[i] = newProbeResult(address)
+				for _, scheme := range probeSchemes {
+					results[i].Errors[scheme] = err
+				}
+
+				return
+			}
+			defer release()
+
+			results[i] = p.Probe(ctx, address)
+		}(i, address)
+	}
+
+	wg.Wait()
+
+	return results
+}
+
+// semaphore returns a semaphore limiting probes to the prober's concurrency, or nil if there is no limit.
+func (p *Prober) semaphore() chan struct{} {
+	if p.Concurrency <= 0 {
+		return nil
+	}
+
+	return make(chan struct{}, p.Concurrency)
+}
+
+// try connects to the address and runs a single check over the connection.
+func (p *Prober) try(ctx context.Context, address string, check func(net.Conn) (Capability, error)) (Capability, error) {
+	var dialer proxy.ContextDialer = &net.Dialer{}
+	if p.Dialer != nil {
+		dialer = p.Dialer
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	return check(conn)
+}
+
+// checkSOCKS5 sends the SOCKS5 greeting, offering no authentication and username/password authentication, and
+// checks that the reply is a SOCKS5 reply. If the proxy chooses username/password authentication, it requires
+// credentials.
+func (p *Prober) checkSOCKS5(conn net.Conn) (Capability, error) {
+	if _, err := conn.Write([]byte{0x05, 0x02, 0x00, 0x02}); err != nil {
+		return 0, err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return 0, err
+	}
+
+	if reply[0] != 0x05 {
+		return 0, fmt.Errorf("not a socks5 reply")
+	}
+
+	if reply[1] == 0x02 {
+		return CapabilityAuth, nil
+	}
+
+	return 0, nil
+}
+
+// checkSOCKS4 sends a SOCKS4 CONNECT request for the target and checks that the reply is a SOCKS4 reply.
+func (p *Prober) checkSOCKS4(conn net.Conn) (Capability, error) {
+	host, rawport, err := net.SplitHostPort(p.Target)
+	if err != nil {
+		return 0, fmt.Errorf("invalid probe target %v: %v", p.Target, err)
+	}
+
+	ip := net.ParseIP(host).To4()
+	port, err := strconv.ParseUint(rawport, 10, 16)
+	if ip == nil || err != nil {
+		return 0, fmt.Errorf("probe target %v is not an IPv4 address and port", p.Target)
+	}
+
+	// Version 4, CONNECT, then the port and IP address, and an empty user ID.
+	request := []byte{0x04, 0x01, 0, 0}
+	binary.BigEndian.PutUint16(request[2:], uint16(port))
+	request = append(request, ip...)
+	request = append(request, 0)
+
+	if _, err := conn.Write(request); err != nil {
+		return 0, err
+	}
+
+	reply := make([]byte, 8)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return 0, err
+	}
+
+	// Replies from 0x5a to 0x5d are granted, rejected, and rejected because of the user ID.
+	if reply[0] != 0x00 || reply[1] < 0x5a || reply[1] > 0x5d {
+		return 0, fmt.Errorf("not a socks4 reply")
+	}
+
+	return 0, nil
+}
+
+// checkHTTP sends a CONNECT request for the target and checks that it is accepted, in which case the proxy can
+// tunnel connections, or that the proxy asks for credentials.
+func (p *Prober) checkHTTP(conn net.Conn) (Capability, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: p.Target},
+		Host:   p.Target,
+		Header: http.Header{},
+	}
+
+	if err := req.Write(conn); err != nil {
+		return 0, err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode/100 == 2:
+		return CapabilityConnect, nil
+	case resp.StatusCode == http.StatusProxyAuthRequired:
+		return CapabilityAuth, nil
+	}
+
+	return 0, fmt.Errorf("CONNECT request returned status %d", resp.StatusCode)
+}
+
+// parseDetectableAddress parses an address like ParseAddress. If scheme is SchemeDetect and the address doesn't
+// have a scheme of its own, the URL returned has the scheme SchemeDetect, to be probed later.
+func parseDetectableAddress(address string, scheme string) (*url.URL, error) {
+	if scheme != SchemeDetect || strings.Contains(address, "://") {
+		return ParseAddress(address, scheme)
+	}
+
+	u, err := ParseAddress(address, "http")
+	if err != nil {
+		return nil, err
+	}
+
+	u.Scheme = SchemeDetect
+	return u, nil
+}
+
+// detectingSet is a set which probes the proxies added to it with the scheme SchemeDetect in the background,
+// using the prober given, and adds them with the scheme of the protocol they speak.
+// Wait has to be called before the proxies in the set are used.
+type detectingSet struct {
+	*Set
+
+	ctx    context.Context
+	prober *Prober
+	sem    chan struct{}
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex
+	probed map[string]bool
+}
+
+func newDetectingSet(ctx context.Context, proxies *Set, prober *Prober) *detectingSet {
+	return &detectingSet{
+		Set:    proxies,
+		ctx:    ctx,
+		prober: prober,
+		sem:    prober.semaphore(),
+		probed: make(map[string]bool),
+	}
+}
+
+// Add adds a proxy to the set, probing it first if its scheme is SchemeDetect. Each address is only probed once,
+// and later proxies with the same address are counted as duplicates.
+func (d *detectingSet) Add(p Proxy) {
+	if p.URL == nil || p.URL.Scheme != SchemeDetect {
+		d.Set.Add(p)
+		return
+	}
+
+	d.mu.Lock()
+	seen := d.probed[p.key()]
+	d.probed[p.key()] = true
+	d.mu.Unlock()
+
+	if seen {
+		d.Set.m.Lock()
+		d.Set.duplicates++
+		d.Set.m.Unlock()
+
+		return
+	}
+
+	d.wg.Add(1)
+
+	go func() {
+		defer d.wg.Done()
+
+		release, err := acquire(d.ctx, d.sem)
+		if err != nil {
+			d.Reject(RejectUnknownProtocol)
+			return
+		}
+		defer release()
+
+		result := d.prober.Probe(d.ctx, p.URL.Host)
+		if result.Scheme() == "" {
+			logger.Debugf("providers (%v): %v doesn't speak any known proxy protocol", p.Provider, p.URL.Host)
+			d.Reject(RejectUnknownProtocol)
+			return
+		}
+
+		u := *p.URL
+		u.Scheme = result.Scheme()
+		p.URL = &u
+		p.Capabilities |= result.Capabilities[u.Scheme]
+
+		d.Set.Add(p)
+	}()
+}
+
+// Wait waits for every proxy being probed to be added or rejected.
+func (d *detectingSet) Wait() {
+	d.wg.Wait()
+}
@@ -0,0 +1,244 @@
+package providers_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ollybritton/prox/providers"
+	"github.com/stretchr/testify/assert"
+)
+
+// startFakeProxy starts a server which passes each connection to handle, and returns its address.
+func startFakeProxy(t *testing.T, handle func(conn net.Conn)) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+// fakeSOCKS5 answers the SOCKS5 greeting asking for a username and password, and closes anything else.
+func fakeSOCKS5(conn net.Conn) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil || header[0] != 5 {
+		return
+	}
+
+	io.ReadFull(conn, make([]byte, header[1]))
+	conn.Write([]byte{5, 2})
+}
+
+// fakeSOCKS4 rejects every SOCKS4 request, and closes anything else.
+func fakeSOCKS4(conn net.Conn) {
+	request := make([]byte, 9)
+	if _, err := io.ReadFull(conn, request[:1]); err != nil || request[0] != 4 {
+		return
+	}
+
+	io.ReadFull(conn, request[1:])
+	conn.Write([]byte{0, 0x5b, 0, 0, 0, 0, 0, 0})
+}
+
+// fakeHTTPProxy asks for credentials in reply to every CONNECT request, and closes anything else.
+func fakeHTTPProxy(conn net.Conn) {
+	fakeHTTPProxyStatus(conn, http.StatusProxyAuthRequired)
+}
+
+// fakeOpenHTTPProxy accepts every CONNECT request, and closes anything else.
+func fakeOpenHTTPProxy(conn net.Conn) {
+	fakeHTTPProxyStatus(conn, http.StatusOK)
+}
+
+func fakeHTTPProxyStatus(conn net.Conn, status int) {
+	r := bufio.NewReader(conn)
+	if first, err := r.Peek(1); err != nil || first[0] != 'C' {
+		return
+	}
+
+	req, err := http.ReadRequest(r)
+	if err != nil || req.Method != http.MethodConnect {
+		return
+	}
+
+	resp := &http.Response{StatusCode: status, ProtoMajor: 1, ProtoMinor: 1, Request: req}
+	resp.Write(conn)
+}
+
+// closedAddress returns an address nothing is listening on.
+func closedAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := listener.Addr().String()
+	listener.Close()
+
+	return addr
+}
+
+func testProber() *providers.Prober {
+	prober := providers.NewProber()
+	prober.Timeout = 2 * time.Second
+
+	return prober
+}
+
+// TestProbe tests that the prober finds the protocol each proxy speaks, even when the proxy refuses the request.
+func TestProbe(t *testing.T) {
+	tests := []struct {
+		address      string
+		want         []string
+		capabilities providers.Capability
+	}{
+		{startFakeProxy(t, fakeSOCKS5), []string{"socks5"}, providers.CapabilityAuth},
+		{startFakeProxy(t, fakeSOCKS4), []string{"socks4"}, 0},
+		{startFakeProxy(t, fakeHTTPProxy), []string{"http"}, providers.CapabilityAuth},
+		{startFakeProxy(t, fakeOpenHTTPProxy), []string{"http"}, providers.CapabilityConnect},
+		{closedAddress(t), []string{}, 0},
+	}
+
+	prober := testProber()
+
+	for _, test := range tests {
+		result := prober.Probe(context.Background(), test.address)
+
+		assert.Equal(t, test.address, result.Address)
+		assert.Equal(t, test.want, result.Protocols, test.address)
+		assert.Len(t, result.Errors, 3-len(test.want))
+
+		for _, scheme := range test.want {
+			assert.True(t, result.Speaks(strings.ToUpper(scheme)))
+			assert.Equal(t, test.capabilities, result.Capabilities[scheme], test.address)
+		}
+	}
+}
+
+// TestProbeWebServer tests that ordinary web servers aren't mistaken for HTTP proxies.
+func TestProbeWebServer(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	// Web servers wait for the rest of a SOCKS4 request, so the prober has to time out.
+	prober := testProber()
+	prober.Timeout = 500 * time.Millisecond
+
+	result := prober.Probe(context.Background(), strings.TrimPrefix(server.URL, "http://"))
+	assert.Equal(t, "", result.Scheme())
+	assert.Contains(t, fmt.Sprint(result.Errors["http"]), "404")
+}
+
+// TestProbeTimeout tests that probing a proxy which never answers gives up after the prober's timeout.
+func TestProbeTimeout(t *testing.T) {
+	address := startFakeProxy(t, func(conn net.Conn) {
+		io.Copy(ioutil.Discard, conn)
+	})
+
+	prober := testProber()
+	prober.Timeout = 200 * time.Millisecond
+
+	started := time.Now()
+	result := prober.Probe(context.Background(), address)
+
+	assert.Equal(t, "", result.Scheme())
+	assert.Less(t, int64(time.Since(started)), int64(2*time.Second))
+}
+
+// TestProbeAll tests that results are returned in the same order as the addresses.
+func TestProbeAll(t *testing.T) {
+	addresses := []string{startFakeProxy(t, fakeSOCKS4), startFakeProxy(t, fakeSOCKS5), closedAddress(t)}
+
+	prober := testProber()
+	prober.Concurrency = 1
+
+	results := prober.ProbeAll(context.Background(), addresses)
+	if assert.Len(t, results, 3) {
+		assert.Equal(t, "socks4", results[0].Scheme())
+		assert.Equal(t, "socks5", results[1].Scheme())
+		assert.Equal(t, "", results[2].Scheme())
+	}
+}
+
+// TestListDetectScheme tests that a list whose default scheme is SchemeDetect probes the addresses without a
+// scheme, and rejects those which don't speak any protocol.
+func TestListDetectScheme(t *testing.T) {
+	socks5 := startFakeProxy(t, fakeSOCKS5)
+	socks4 := startFakeProxy(t, fakeSOCKS4)
+	http := startFakeProxy(t, fakeHTTPProxy)
+
+	list := strings.Join([]string{
+		socks5 + " GB",
+		"user:pass@" + socks4 + " GB",
+		http + " GB",
+		"socks4://" + http + " GB",
+		closedAddress(t) + " GB",
+	}, "\n")
+
+	provider := providers.NewReaderList(strings.NewReader(list), providers.ListDefaultScheme(providers.SchemeDetect))
+	ps, report, err := providers.Fetch("List", provider, 5*time.Second)
+	assert.Nil(t, err)
+
+	assert.ElementsMatch(t, []string{
+		"socks5://" + socks5,
+		"socks4://user:pass@" + socks4,
+		"http://" + http,
+		"socks4://" + http,
+	}, proxyURLs(ps), "addresses with a scheme of their own shouldn't be probed")
+
+	assert.Equal(t, 1, report.Rejected[providers.RejectUnknownProtocol])
+}
+
+// TestGetProxyListDetectScheme tests that proxies listed by GetProxyList without a protocol are probed.
+func TestGetProxyListDetectScheme(t *testing.T) {
+	host, port, _ := net.SplitHostPort(startFakeProxy(t, fakeSOCKS5))
+
+	var requests int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) > 3 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		fmt.Fprintf(w, `{"ip": %q, "port": %v, "protocol": "", "country": "US", "allowsHttps": true}`, host, port)
+	}))
+	defer server.Close()
+
+	engine := fixtureEngine()
+	engine.MaxConcurrency = 1
+
+	ps, report, err := providers.Fetch("GetProxyList", providers.NewGetProxyList(server.URL, engine), 5*time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"socks5://" + net.JoinHostPort(host, port)}, proxyURLs(ps))
+	assert.Equal(t, 2, report.Duplicate, "the same address should only be probed once")
+
+	if assert.Len(t, ps, 1) {
+		assert.Equal(t, providers.CapabilityConnect|providers.CapabilityAuth, ps[0].Capabilities, "capabilities should be declared by the API and found by probing")
+	}
+}
@@ -0,0 +1,227 @@
+package providers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// The formats a Command can print proxies in.
+const (
+	FormatLines     = "lines"
+	FormatJSONLines = "jsonl"
+)
+
+// Command describes a provider which runs an external program and reads proxies from what it prints. This
+// allows proxy sources to be written in any language and kept outside of prox.
+type Command struct {
+	Name    string   `yaml:"name" json:"name"`
+	Command string   `yaml:"command" json:"command"`
+	Args    []string `yaml:"args,omitempty" json:"args,omitempty"`
+
+	// Description is a short explanation of where the proxies come from.
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+
+	// Env holds extra environment variables for the program, in the form KEY=value.
+	Env []string `yaml:"env,omitempty" json:"env,omitempty"`
+
+	// Format is how the program prints proxies, one per line. With FormatLines, the default, each line can be in
+	// any format accepted by NewFileList. With FormatJSONLines, each line is an object like
+	//
+	//	{"url": "socks5://1.1.1.1:1080", "country": "AU"}
+	//	{"ip": "1.1.1.1", "port": 1080, "scheme": "socks5"}
+	//	{"address": "1.1.1.1:1080"}
+	//
+	// where the country, an ISO code or a country name, is looked up from the IP address if it is missing and the
+	// host is an IP address.
+	Format string `yaml:"format,omitempty" json:"format,omitempty"`
+
+	// Scheme is the scheme used for proxies which don't have one. By default it is "http". If it is SchemeDetect,
+	// proxies without a scheme are probed to find out which protocol they speak.
+	Scheme string `yaml:"scheme,omitempty" json:"scheme,omitempty"`
+}
+
+// commandProxy is a single proxy printed by a command using FormatJSONLines.
+type commandProxy struct {
+	URL     string      `json:"url"`
+	Address string      `json:"address"`
+	IP      string      `json:"ip"`
+	Port    json.Number `json:"port"`
+	Scheme  string      `json:"scheme"`
+	Country string      `json:"country"`
+}
+
+// Validate checks that the command is complete.
+func (c Command) Validate() error {
+	if c.Name == "" {
+		return errors.New("providers: command has no name")
+	}
+
+	if c.Command == "" {
+		return fmt.Errorf("providers (%v): no command given", c.Name)
+	}
+
+	switch c.Format {
+	case "", FormatLines, FormatJSONLines:
+	default:
+		return fmt.Errorf("providers (%v): unknown format %q", c.Name, c.Format)
+	}
+
+	return nil
+}
+
+// NewCommandProvider creates a provider which runs the command given each time it is used. Proxies are added to
+// the set as soon as they are printed, and the program is killed once the provider's timeout has passed.
+// Anything the program prints to stderr is logged.
+func NewCommandProvider(c Command) (Provider, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	if c.Format == "" {
+		c.Format = FormatLines
+	}
+
+	if c.Scheme == "" {
+		c.Scheme = "http"
+	}
+
+	return c.provide, nil
+}
+
+func (c Command) provide(proxies *Set, req Request) ([]Proxy, error) {
+	logger.Debugf("providers: Fetching proxies from provider %v", c.Name)
+
+	ctx, cancel := context.WithTimeout(context.Background(), req.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, c.Command, c.Args...)
+	cmd.Env = append(os.Environ(), c.Env...)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return []Proxy{}, errors.Wrapf(err, "providers (%v): cannot run command", c.Name)
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return []Proxy{}, errors.Wrapf(err, "providers (%v): cannot run command", c.Name)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return []Proxy{}, errors.Wrapf(err, "providers (%v): cannot run command", c.Name)
+	}
+
+	go c.logStderr(stderr)
+
+	done := make(chan struct{})
+	go func() {
+		c.read(newDetectingSet(ctx, proxies, req.prober()), stdout)
+		close(done)
+	}()
+
+	// If the program is killed, Wait closes stdout, so this can't block for longer than the timeout even if the
+	// program has started children of its own which are still running.
+	select {
+	case <-done:
+	case <-ctx.Done():
+		logger.Debugf("providers (%v): command timed out after %v, killing it", c.Name, req.Timeout)
+	}
+
+	err = cmd.Wait()
+	<-done
+
+	if err != nil && ctx.Err() == nil {
+		logger.Debugf("providers (%v): command failed: %v", c.Name, err)
+	}
+
+	ps := proxies.List()
+	if len(ps) == 0 {
+		if ctx.Err() == context.DeadlineExceeded {
+			return ps, fmt.Errorf("providers (%v): no proxies could be gathered: command timed out after %v", c.Name, req.Timeout)
+		}
+
+		if err != nil {
+			return ps, fmt.Errorf("providers (%v): no proxies could be gathered: %v", c.Name, err)
+		}
+
+		return ps, fmt.Errorf("providers (%v): no proxies could be gathered", c.Name)
+	}
+
+	return ps, nil
+}
+
+// read adds the proxies printed by the program to the set until stdout is closed and any proxies without a
+// scheme have been probed.
+func (c Command) read(proxies *detectingSet, stdout io.Reader) {
+	defer proxies.Wait()
+
+	scanner := bufio.NewScanner(stdout)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var (
+			proxy  Proxy
+			reason RejectReason
+			err    error
+		)
+
+		if c.Format == FormatJSONLines {
+			proxy, reason, err = c.parseJSONLine(line)
+		} else {
+			proxy, reason, err = parseListLine(line, c.Name, c.Scheme)
+		}
+
+		if err != nil {
+			logger.Debugf("providers (%v): %v", c.Name, err)
+			proxies.Reject(reason)
+			continue
+		}
+
+		proxies.Add(proxy)
+	}
+}
+
+func (c Command) parseJSONLine(line string) (Proxy, RejectReason, error) {
+	p := commandProxy{}
+	if err := json.Unmarshal([]byte(line), &p); err != nil {
+		return Proxy{}, RejectParseFailure, fmt.Errorf("invalid json line %q: %v", redactCredentials(line), err)
+	}
+
+	scheme := strings.ToLower(p.Scheme)
+	if scheme == "" {
+		scheme = c.Scheme
+	}
+
+	address := p.URL
+	if address == "" {
+		address = p.Address
+	}
+
+	if address == "" {
+		address = net.JoinHostPort(p.IP, p.Port.String())
+	}
+
+	return buildProxy(c.Name, scheme, address, p.Country)
+}
+
+// logStderr logs each line the program prints to stderr.
+func (c Command) logStderr(stderr io.Reader) {
+	scanner := bufio.NewScanner(stderr)
+
+	for scanner.Scan() {
+		logger.Infof("providers (%v): %v", c.Name, scanner.Text())
+	}
+}
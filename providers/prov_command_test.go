@@ -0,0 +1,126 @@
+package providers_test
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/ollybritton/prox/providers"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCommandHelper isn't a real test. It is run as the external program by the command provider tests, and
+// behaves according to PROX_HELPER.
+func TestCommandHelper(t *testing.T) {
+	switch os.Getenv("PROX_HELPER") {
+	case "lines":
+		fmt.Println("socks5://1.1.1.1:1080 AU")
+		fmt.Println("8.8.8.8:8080")
+		fmt.Println("not a proxy line")
+		fmt.Fprintln(os.Stderr, "finished listing proxies")
+	case "jsonl":
+		fmt.Println(`{"url": "socks5://1.1.1.1:1080", "country": "AU"}`)
+		fmt.Println(`{"ip": "8.8.8.8", "port": 8080, "scheme": "HTTPS"}`)
+		fmt.Println(`{"address": "9.9.9.9:3128", "country": "United States"}`)
+		fmt.Println(`{"ip": "8.8.4.4"}`)
+		fmt.Println(`{not json`)
+	case "fail":
+		fmt.Fprintln(os.Stderr, "no upstream available")
+		os.Exit(1)
+	default:
+		return
+	}
+
+	os.Exit(0)
+}
+
+// helperCommand returns a command which runs TestCommandHelper with the behaviour given.
+func helperCommand(behaviour string, format string) providers.Command {
+	return providers.Command{
+		Name:    "Helper",
+		Command: os.Args[0],
+		Args:    []string{"-test.run=^TestCommandHelper$"},
+		Env:     []string{"PROX_HELPER=" + behaviour},
+		Format:  format,
+	}
+}
+
+func fetchCommand(t *testing.T, c providers.Command, timeout time.Duration) ([]providers.Proxy, providers.Report, error) {
+	provider, err := providers.NewCommandProvider(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return providers.Fetch(c.Name, provider, timeout)
+}
+
+// TestCommandProviderLines tests reading proxies printed by a program in the list formats.
+func TestCommandProviderLines(t *testing.T) {
+	ps, report, err := fetchCommand(t, helperCommand("lines", ""), 10*time.Second)
+
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{
+		"socks5://1.1.1.1:1080": "AU",
+		"http://8.8.8.8:8080":   "US",
+	}, proxyCountries(ps))
+	assert.Equal(t, 1, report.Rejected[providers.RejectParseFailure])
+}
+
+// TestCommandProviderJSONLines tests reading proxies printed by a program as JSON objects.
+func TestCommandProviderJSONLines(t *testing.T) {
+	ps, report, err := fetchCommand(t, helperCommand("jsonl", providers.FormatJSONLines), 10*time.Second)
+
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{
+		"socks5://1.1.1.1:1080": "AU",
+		"https://8.8.8.8:8080":  "US",
+		"http://9.9.9.9:3128":   "US",
+	}, proxyCountries(ps))
+	assert.Equal(t, 1, report.Rejected[providers.RejectParseFailure])
+	assert.Equal(t, 1, report.Rejected[providers.RejectMalformedIP])
+}
+
+// TestCommandProviderTimeout tests that a program which runs for longer than the timeout is killed, and that
+// the proxies it printed before then are kept. The programs are run by sh rather than being the test binary, so
+// that starting them doesn't take up the timeout.
+func TestCommandProviderTimeout(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is needed to run the slow programs")
+	}
+
+	slow := providers.Command{Name: "Slow", Command: "sh", Args: []string{"-c", "echo 'http://1.1.1.1:80 AU'; exec sleep 5"}}
+
+	started := time.Now()
+	ps, _, err := fetchCommand(t, slow, time.Second)
+
+	assert.Nil(t, err)
+	assert.Len(t, ps, 1)
+	assert.True(t, time.Since(started) < 4*time.Second, "the program should be killed after the timeout")
+
+	silent := providers.Command{Name: "Silent", Command: "sh", Args: []string{"-c", "exec sleep 5"}}
+
+	started = time.Now()
+	_, _, err = fetchCommand(t, silent, time.Second)
+
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "timed out")
+	}
+	assert.True(t, time.Since(started) < 4*time.Second, "the program should be killed after the timeout")
+}
+
+// TestCommandProviderErrors tests that a failing program and an invalid command are reported as errors.
+func TestCommandProviderErrors(t *testing.T) {
+	_, _, err := fetchCommand(t, helperCommand("fail", ""), 10*time.Second)
+	assert.NotNil(t, err)
+
+	_, _, err = fetchCommand(t, providers.Command{Name: "Missing", Command: "/nonexistent/prox-provider"}, time.Second)
+	assert.NotNil(t, err)
+
+	_, err = providers.NewCommandProvider(providers.Command{Name: "NoCommand"})
+	assert.NotNil(t, err)
+
+	_, err = providers.NewCommandProvider(providers.Command{Name: "BadFormat", Command: "true", Format: "xml"})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,388 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// The formats a Definition can have.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+	FormatHTML = "html"
+)
+
+// defaultMaxPages is the most pages followed using Pagination.Next if MaxPages isn't set.
+const defaultMaxPages = 10
+
+// Definition describes a provider declaratively, so that a new source of proxies can be added without writing
+// any Go. Definitions are usually loaded from YAML or JSON with LoadDefinitions.
+type Definition struct {
+	Name string   `yaml:"name" json:"name"`
+	URLs []string `yaml:"urls" json:"urls"`
+
+	// Description is a short explanation of where the proxies come from.
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+
+	// Format is how each page is parsed. It is one of FormatText, FormatJSON or FormatHTML.
+	//
+	// Text pages have one proxy per line, in any format accepted by NewFileList. JSON pages have a list of
+	// proxies at the path Items, and HTML pages have a proxy in each element matching the CSS selector Rows.
+	Format string `yaml:"format" json:"format"`
+
+	// Scheme is the scheme used for proxies which don't have one. By default it is "http". If it is SchemeDetect,
+	// proxies without a scheme are probed to find out which protocol they speak.
+	Scheme string `yaml:"scheme,omitempty" json:"scheme,omitempty"`
+
+	// Items is the dot-separated path to the list of proxies in a JSON page, such as "data.proxies". If it is
+	// empty, the page itself should be a list.
+	Items string `yaml:"items,omitempty" json:"items,omitempty"`
+
+	// Rows is the CSS selector matching each proxy in a HTML page. By default it is "table tr".
+	Rows string `yaml:"rows,omitempty" json:"rows,omitempty"`
+
+	Fields     Fields      `yaml:"fields,omitempty" json:"fields,omitempty"`
+	Pagination *Pagination `yaml:"pagination,omitempty" json:"pagination,omitempty"`
+}
+
+// Fields maps the parts of a proxy to where they can be found in a JSON item or a HTML row. For JSON, each is a
+// dot-separated path within the item. For HTML, each is a CSS selector within the row, and the text of the
+// element is used.
+//
+// Either Address, in the form host:port, or both IP and Port must be given. Scheme and Country are optional.
+// Country can be an ISO code or a country name, and is looked up from the IP address if it is missing and the host
+// is an IP address.
+type Fields struct {
+	Address string `yaml:"address,omitempty" json:"address,omitempty"`
+	IP      string `yaml:"ip,omitempty" json:"ip,omitempty"`
+	Port    string `yaml:"port,omitempty" json:"port,omitempty"`
+	Scheme  string `yaml:"scheme,omitempty" json:"scheme,omitempty"`
+	Country string `yaml:"country,omitempty" json:"country,omitempty"`
+}
+
+// Pagination describes how to find further pages of proxies.
+type Pagination struct {
+	// Start and End are the range of page numbers substituted for "{page}" in each URL, inclusive.
+	Start int `yaml:"start,omitempty" json:"start,omitempty"`
+	End   int `yaml:"end,omitempty" json:"end,omitempty"`
+
+	// Next is where to find the URL of the next page: a CSS selector for a link in HTML pages, or a path in
+	// JSON pages. MaxPages limits how many pages are followed this way, and is 10 by default.
+	Next     string `yaml:"next,omitempty" json:"next,omitempty"`
+	MaxPages int    `yaml:"max_pages,omitempty" json:"max_pages,omitempty"`
+}
+
+// Validate checks that the definition is complete.
+func (d Definition) Validate() error {
+	if d.Name == "" {
+		return errors.New("providers: definition has no name")
+	}
+
+	if len(d.URLs) == 0 {
+		return fmt.Errorf("providers (%v): definition has no urls", d.Name)
+	}
+
+	switch d.Format {
+	case FormatText:
+		return nil
+	case FormatJSON, FormatHTML:
+	default:
+		return fmt.Errorf("providers (%v): unknown format %q", d.Name, d.Format)
+	}
+
+	if d.Fields.Address == "" && (d.Fields.IP == "" || d.Fields.Port == "") {
+		return fmt.Errorf("providers (%v): definition needs either an address field or ip and port fields", d.Name)
+	}
+
+	return nil
+}
+
+// LoadDefinitions reads a list of definitions from YAML or JSON.
+func LoadDefinitions(r io.Reader) ([]Definition, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "providers: cannot read definitions")
+	}
+
+	defs := []Definition{}
+	if err := yaml.Unmarshal(data, &defs); err != nil {
+		return nil, errors.Wrap(err, "providers: cannot parse definitions")
+	}
+
+	for _, def := range defs {
+		if err := def.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return defs, nil
+}
+
+// definedProvider is a provider created from a Definition.
+type definedProvider struct {
+	Definition
+	engine *Engine
+}
+
+// NewDefinedProvider creates a provider from a definition, which requests pages using the engine given. If the
+// engine is nil, DefaultEngine is used.
+func NewDefinedProvider(def Definition, engine *Engine) (Provider, error) {
+	if err := def.Validate(); err != nil {
+		return nil, err
+	}
+
+	if def.Scheme == "" {
+		def.Scheme = "http"
+	}
+
+	if def.Rows == "" {
+		def.Rows = "table tr"
+	}
+
+	d := &definedProvider{Definition: def, engine: engine}
+	return d.provide, nil
+}
+
+func (d *definedProvider) provide(proxies *Set, req Request) ([]Proxy, error) {
+	logger.Debugf("providers: Fetching proxies from provider %v", d.Name)
+
+	ctx, cancel := req.context()
+	defer cancel()
+
+	engineOrDefault(d.engine).GetAll(ctx, d.pages(), func(page string, body []byte, err error) {
+		d.handle(ctx, req.prober(), proxies, page, body, err, 1)
+	})
+
+	ps := proxies.List()
+	if len(ps) == 0 {
+		return ps, fmt.Errorf("providers (%v): no proxies could be gathered", d.Name)
+	}
+
+	return ps, nil
+}
+
+// pages returns the URLs of the first pages to request, with the page numbers substituted in.
+func (d *definedProvider) pages() []string {
+	if d.Pagination == nil || d.Pagination.End < d.Pagination.Start {
+		return d.URLs
+	}
+
+	pages := []string{}
+	for _, u := range d.URLs {
+		if !strings.Contains(u, "{page}") {
+			pages = append(pages, u)
+			continue
+		}
+
+		for n := d.Pagination.Start; n <= d.Pagination.End; n++ {
+			pages = append(pages, strings.ReplaceAll(u, "{page}", strconv.Itoa(n)))
+		}
+	}
+
+	return pages
+}
+
+// handle parses a page and follows the link to the next page, if there is one.
+func (d *definedProvider) handle(ctx context.Context, prober *Prober, proxies *Set, page string, body []byte, err error, depth int) {
+	if err != nil {
+		logger.Debugf("providers (%v): cannot request page %v: %v", d.Name, page, err)
+		proxies.HTTPError()
+		return
+	}
+
+	next := d.parse(ctx, prober, proxies, body)
+	if next == "" || d.Pagination == nil {
+		return
+	}
+
+	max := d.Pagination.MaxPages
+	if max == 0 {
+		max = defaultMaxPages
+	}
+
+	if depth >= max {
+		return
+	}
+
+	base, err := url.Parse(page)
+	if err != nil {
+		return
+	}
+
+	ref, err := url.Parse(next)
+	if err != nil {
+		logger.Debugf("providers (%v): invalid next page link %q: %v", d.Name, next, err)
+		return
+	}
+
+	nextPage := base.ResolveReference(ref).String()
+
+	body, err = engineOrDefault(d.engine).Get(ctx, nextPage)
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+
+	d.handle(ctx, prober, proxies, nextPage, body, err, depth+1)
+}
+
+// parse adds the proxies on a page to the set, and returns the link to the next page if there is one. Proxies
+// without a scheme are probed with the prober given before it returns if the definition's scheme is SchemeDetect.
+func (d *definedProvider) parse(ctx context.Context, prober *Prober, set *Set, body []byte) string {
+	if d.Format == FormatText {
+		parseList(ctx, prober, set, d.Name, d.Scheme, splitLines(body))
+		return ""
+	}
+
+	proxies := newDetectingSet(ctx, set, prober)
+	defer proxies.Wait()
+
+	switch d.Format {
+	case FormatJSON:
+		return d.parseJSON(proxies, body)
+	case FormatHTML:
+		return d.parseHTML(proxies, body)
+	}
+
+	return ""
+}
+
+func (d *definedProvider) parseJSON(proxies *detectingSet, body []byte) string {
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		logger.Debugf("providers (%v): could not unmarshal json response: %v", d.Name, err)
+		proxies.Reject(RejectParseFailure)
+		return ""
+	}
+
+	items, ok := jsonPath(doc, d.Items).([]interface{})
+	if !ok {
+		logger.Debugf("providers (%v): no list of proxies at path %q", d.Name, d.Items)
+		proxies.Reject(RejectParseFailure)
+		return ""
+	}
+
+	for _, item := range items {
+		field := func(path string) string {
+			if path == "" {
+				return ""
+			}
+
+			return jsonString(jsonPath(item, path))
+		}
+
+		d.addRow(proxies, field)
+	}
+
+	if d.Pagination == nil || d.Pagination.Next == "" {
+		return ""
+	}
+
+	return jsonString(jsonPath(doc, d.Pagination.Next))
+}
+
+func (d *definedProvider) parseHTML(proxies *detectingSet, body []byte) string {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		logger.Debugf("providers (%v): could not parse html response: %v", d.Name, err)
+		proxies.Reject(RejectParseFailure)
+		return ""
+	}
+
+	doc.Find(d.Rows).Each(func(i int, row *goquery.Selection) {
+		field := func(selector string) string {
+			if selector == "" {
+				return ""
+			}
+
+			return strings.TrimSpace(row.Find(selector).First().Text())
+		}
+
+		// Rows without an address, like table headers, aren't proxies at all.
+		if field(d.Fields.Address) == "" && field(d.Fields.IP) == "" {
+			return
+		}
+
+		d.addRow(proxies, field)
+	})
+
+	if d.Pagination == nil || d.Pagination.Next == "" {
+		return ""
+	}
+
+	return doc.Find(d.Pagination.Next).First().AttrOr("href", "")
+}
+
+// addRow adds the proxy described by a single JSON item or HTML row to the set. The field function returns the
+// value of a field given its path or selector.
+func (d *definedProvider) addRow(proxies *detectingSet, field func(string) string) {
+	address := field(d.Fields.Address)
+	if address == "" {
+		address = net.JoinHostPort(field(d.Fields.IP), field(d.Fields.Port))
+	}
+
+	scheme := strings.ToLower(field(d.Fields.Scheme))
+	if scheme == "" {
+		scheme = d.Scheme
+	}
+
+	proxy, reason, err := buildProxy(d.Name, scheme, address, field(d.Fields.Country))
+	if err != nil {
+		logger.Debugf("providers (%v): %v", d.Name, err)
+		proxies.Reject(reason)
+		return
+	}
+
+	proxies.Add(proxy)
+}
+
+// jsonPath follows a dot-separated path of keys through a decoded JSON value. Numeric keys index into lists.
+// An empty path returns the value itself.
+func jsonPath(v interface{}, path string) interface{} {
+	if path == "" {
+		return v
+	}
+
+	for _, key := range strings.Split(path, ".") {
+		switch value := v.(type) {
+		case map[string]interface{}:
+			v = value[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(value) {
+				return nil
+			}
+
+			v = value[i]
+		default:
+			return nil
+		}
+	}
+
+	return v
+}
+
+// jsonString formats a decoded JSON value as a string, so that ports can be given as numbers.
+func jsonString(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(value)
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	default:
+		return fmt.Sprint(value)
+	}
+}
@@ -0,0 +1,125 @@
+package providers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ollybritton/prox/providers"
+	"github.com/stretchr/testify/assert"
+)
+
+// loadDefinitions loads the definitions in testdata/defined, pointing their URLs at the server given.
+func loadDefinitions(t *testing.T, server *httptest.Server) map[string]providers.Definition {
+	f, err := os.Open("testdata/defined/definitions.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	defs, err := providers.LoadDefinitions(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byName := map[string]providers.Definition{}
+	for _, def := range defs {
+		for i := range def.URLs {
+			def.URLs[i] = server.URL + def.URLs[i]
+		}
+
+		byName[def.Name] = def
+	}
+
+	return byName
+}
+
+func fetchDefinition(t *testing.T, def providers.Definition) ([]providers.Proxy, providers.Report) {
+	provider, err := providers.NewDefinedProvider(def, fixtureEngine())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ps, report, err := providers.Fetch(def.Name, provider, 5*time.Second)
+	assert.Nil(t, err)
+
+	return ps, report
+}
+
+func proxyCountries(ps []providers.Proxy) map[string]string {
+	countries := map[string]string{}
+	for _, p := range ps {
+		countries[p.URL.String()] = p.Country
+	}
+
+	return countries
+}
+
+// TestDefinedProviders tests providers defined declaratively against recorded text, JSON and HTML pages,
+// including pagination by page number and by following links.
+func TestDefinedProviders(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("testdata/defined")))
+	defer server.Close()
+
+	defs := loadDefinitions(t, server)
+
+	t.Run("Text", func(t *testing.T) {
+		ps, report := fetchDefinition(t, defs["TextList"])
+
+		assert.Equal(t, map[string]string{
+			"socks5://1.1.1.1:1080": "AU",
+			"http://8.8.8.8:80":     "US",
+		}, proxyCountries(ps))
+		assert.Equal(t, 1, report.Rejected[providers.RejectParseFailure])
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		ps, report := fetchDefinition(t, defs["JSONList"])
+
+		assert.Equal(t, map[string]string{
+			"http://1.1.1.1:80":     "AU",
+			"socks5://8.8.8.8:1080": "US",
+			"http://8.8.4.4:8080":   "US",
+		}, proxyCountries(ps))
+		assert.Equal(t, 1, report.Rejected[providers.RejectMalformedIP])
+		assert.Equal(t, 1, report.Rejected[providers.RejectBadCountry])
+	})
+
+	t.Run("HTML", func(t *testing.T) {
+		ps, report := fetchDefinition(t, defs["HTMLTable"])
+
+		assert.Equal(t, map[string]string{
+			"https://1.1.1.1:3128":  "AU",
+			"http://8.8.8.8:8080":   "US",
+			"socks5://9.9.9.9:1080": "US",
+		}, proxyCountries(ps))
+		assert.Equal(t, 1, report.Rejected[providers.RejectMalformedIP])
+		assert.Equal(t, 1, report.HTTPErrors, "the missing third page should be counted as an http error")
+	})
+}
+
+// TestLoadDefinitionsInvalid tests that definitions are validated when they are loaded, and that JSON can be
+// used instead of YAML.
+func TestLoadDefinitionsInvalid(t *testing.T) {
+	defs, err := providers.LoadDefinitions(strings.NewReader(
+		`[{"name": "JSON", "urls": ["http://localhost/list.txt"], "format": "text"}]`,
+	))
+	assert.Nil(t, err)
+	assert.Len(t, defs, 1)
+
+	invalid := []string{
+		`[{"urls": ["http://localhost"], "format": "text"}]`,
+		`[{"name": "NoURLs", "format": "text"}]`,
+		`[{"name": "BadFormat", "urls": ["http://localhost"], "format": "xml"}]`,
+		`[{"name": "NoFields", "urls": ["http://localhost"], "format": "json", "fields": {"ip": "ip"}}]`,
+		`not: [a list`,
+	}
+
+	for _, def := range invalid {
+		_, err := providers.LoadDefinitions(strings.NewReader(def))
+		assert.NotNil(t, err, def)
+	}
+}
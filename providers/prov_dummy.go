@@ -4,11 +4,10 @@ import (
 	"fmt"
 	"net/url"
 	"strings"
-	"time"
 )
 
 // DummyProvider provides a fixed, small provider of proxies. It is used mainly for testing.
-func DummyProvider(proxies *Set, timeout time.Duration) ([]Proxy, error) {
+func DummyProvider(proxies *Set, req Request) ([]Proxy, error) {
 	var ps []Proxy
 
 	staticProxies := []string{
@@ -59,12 +58,12 @@ func DummyProvider(proxies *Set, timeout time.Duration) ([]Proxy, error) {
 
 // DummyProviderEmpty provides an example of a provider that is not working, for the purpose of testing.
 // It does not return an error.
-func DummyProviderEmpty(proxies *Set, timeout time.Duration) ([]Proxy, error) {
+func DummyProviderEmpty(proxies *Set, req Request) ([]Proxy, error) {
 	return []Proxy{}, nil
 }
 
 // DummyProviderError provides an example of a provider that is not working, for the purpose of testing.
 // Unlike DummyProviderNotWoring, this does return a 'no proxies could be gathered' error.
-func DummyProviderError(proxies *Set, timeout time.Duration) ([]Proxy, error) {
+func DummyProviderError(proxies *Set, req Request) ([]Proxy, error) {
 	return []Proxy{}, fmt.Errorf("providers (DummyProviderError): no proxies could be gathered")
 }
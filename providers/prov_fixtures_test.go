@@ -0,0 +1,340 @@
+package providers_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ollybritton/prox/providers"
+	"github.com/stretchr/testify/assert"
+)
+
+// fixtureEngine returns an engine with no limits or retries, so that fixture tests are fast and deterministic.
+func fixtureEngine() *providers.Engine {
+	return &providers.Engine{}
+}
+
+// fixture returns the contents of a file in the testdata directory.
+func fixture(t *testing.T, path string) []byte {
+	bytes, err := ioutil.ReadFile(filepath.Join("testdata", path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bytes
+}
+
+// TestFreeProxyListsFixtures tests the FreeProxyLists provider against recorded listing pages and XML resources,
+// including a missing page, an invalid link, malformed rows and a truncated resource.
+func TestFreeProxyListsFixtures(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("testdata/freeproxylists")))
+	defer server.Close()
+
+	provider := providers.NewFreeProxyLists(server.URL, fixtureEngine())
+	ps, report, err := providers.Fetch("FreeProxyLists", provider, 5*time.Second)
+
+	assert.Nil(t, err)
+	assert.Len(t, ps, 2)
+
+	countries := map[string]string{}
+	for _, p := range ps {
+		countries[p.URL.String()] = p.Country
+	}
+
+	assert.Equal(t, map[string]string{
+		"http://8.8.8.8:8080":     "US",
+		"http://81.2.69.142:3128": "GB",
+	}, countries)
+
+	for _, p := range ps {
+		assert.Equal(t, p.URL.Host == "8.8.8.8:8080", p.Can(providers.CapabilityConnect), "only proxies which support SSL can tunnel connections")
+	}
+
+	assert.Equal(t, 1, report.HTTPErrors, "the missing https.html page should be counted as an http error")
+	assert.Equal(t, 3, report.Rejected[providers.RejectParseFailure])
+	assert.Equal(t, 1, report.Rejected[providers.RejectBadCountry])
+}
+
+// TestProxyScrapeFixtures tests the ProxyScrape provider against recorded plain-text lists, including malformed
+// addresses, CRLF line endings and a failing endpoint.
+func TestProxyScrapeFixtures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		switch {
+		case query.Get("proxytype") == "socks4":
+			w.WriteHeader(http.StatusInternalServerError)
+		case query.Get("proxytype") == "socks5":
+			w.Write(fixture(t, "proxyscrape/socks5.txt"))
+		case query.Get("ssl") == "yes":
+			w.Write(fixture(t, "proxyscrape/https.txt"))
+		default:
+			w.Write(fixture(t, "proxyscrape/http.txt"))
+		}
+	}))
+	defer server.Close()
+
+	provider := providers.NewProxyScrape(server.URL, fixtureEngine())
+	ps, report, err := providers.Fetch("ProxyScrape", provider, 5*time.Second)
+
+	assert.Nil(t, err)
+
+	urls := []string{}
+	for _, p := range ps {
+		urls = append(urls, p.URL.String())
+	}
+
+	assert.ElementsMatch(t, []string{"http://1.1.1.1:80", "http://8.8.4.4:8080", "http://1.0.0.1:443"}, urls)
+
+	for _, p := range ps {
+		assert.Equal(t, p.URL.Host == "1.0.0.1:443", p.Can(providers.CapabilityConnect), "only proxies which support SSL can tunnel connections")
+	}
+	assert.Equal(t, 3, report.Rejected[providers.RejectMalformedIP])
+	assert.Equal(t, 1, report.HTTPErrors)
+}
+
+// TestGetProxyListFixtures tests that the GetProxyList provider parses API responses, rejects malformed ones and
+// stops making requests once the API starts rate limiting.
+func TestGetProxyListFixtures(t *testing.T) {
+	var requests int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&requests, 1) {
+		case 1, 2:
+			w.Write(fixture(t, "getproxylist/proxy.json"))
+		case 3:
+			w.Write(fixture(t, "getproxylist/truncated.json"))
+		default:
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+
+	engine := fixtureEngine()
+	engine.MaxConcurrency = 1
+
+	provider := providers.NewGetProxyList(server.URL, engine)
+	ps, report, err := providers.Fetch("GetProxyList", provider, 5*time.Second)
+
+	assert.Nil(t, err)
+	if assert.Len(t, ps, 1) {
+		assert.Equal(t, "US", ps[0].Country)
+		assert.Equal(t, "http://5.5.5.5:8080", ps[0].URL.String(), "the proxy should have the scheme and port from the response")
+		assert.False(t, ps[0].Can(providers.CapabilityConnect), "the proxy doesn't allow HTTPS")
+	}
+
+	assert.Equal(t, 1, report.Duplicate)
+	assert.Equal(t, 1, report.Rejected[providers.RejectParseFailure])
+	assert.Equal(t, 1, report.HTTPErrors)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&requests), "no requests should be made once the API rate limits")
+}
+
+// TestGetProxyListKeepsInFlight tests that the GetProxyList provider keeps the proxies from requests which were
+// already in flight when the API started rate limiting, and never has more requests in flight than the engine
+// allows.
+func TestGetProxyListKeepsInFlight(t *testing.T) {
+	var requests, inFlight, maxInFlight int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+
+		current := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if current <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, current) {
+				break
+			}
+		}
+
+		if n == 1 {
+			time.Sleep(200 * time.Millisecond)
+			w.Write(fixture(t, "getproxylist/proxy.json"))
+			return
+		}
+
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	engine := fixtureEngine()
+	engine.MaxConcurrency = 4
+
+	provider := providers.NewGetProxyList(server.URL, engine)
+	ps, report, err := providers.Fetch("GetProxyList", provider, 5*time.Second)
+
+	assert.Nil(t, err)
+	assert.Len(t, ps, 1, "the proxy from the slow request should be kept")
+	assert.GreaterOrEqual(t, report.HTTPErrors, 1)
+	assert.LessOrEqual(t, atomic.LoadInt32(&maxInFlight), int32(4))
+	assert.Less(t, atomic.LoadInt32(&requests), int32(10), "no more requests should be started once the API rate limits")
+}
+
+// TestProviderNoProxies tests that a provider returns an error when the site responds but has no proxies.
+func TestProviderNoProxies(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	_, report, err := providers.Fetch("ProxyScrape", providers.NewProxyScrape(server.URL, fixtureEngine()), 5*time.Second)
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, report.Found)
+}
+
+// recordQueries starts a server which responds to every request with the fixture given and records the path and
+// query of each request.
+func recordQueries(t *testing.T, path string) (*httptest.Server, func() []string) {
+	var (
+		mu      sync.Mutex
+		queries []string
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		queries = append(queries, r.URL.RequestURI())
+		mu.Unlock()
+
+		w.Write(fixture(t, path))
+	}))
+
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return append([]string{}, queries...)
+	}
+}
+
+// TestProxyScrapeHints tests that the ProxyScrape provider turns the hints it is given into API parameters.
+func TestProxyScrapeHints(t *testing.T) {
+	server, queries := recordQueries(t, "proxyscrape/http.txt")
+	defer server.Close()
+
+	hints := providers.Hints{Countries: []string{"DE", "FR"}, Schemes: []string{"SOCKS5"}}
+	provider := providers.NewProxyScrape(server.URL, fixtureEngine())
+
+	ps, _, err := providers.FetchWithHints("ProxyScrape", provider, 5*time.Second, hints)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, ps)
+
+	assert.ElementsMatch(t, []string{
+		"/?country=DE&proxytype=socks5&request=getproxies&timeout=10000",
+		"/?country=FR&proxytype=socks5&request=getproxies&timeout=10000",
+	}, queries())
+}
+
+// TestProxyScrapeHintsSSL tests that asking ProxyScrape for SSL proxies only requests HTTPS ones, with the
+// anonymity level given.
+func TestProxyScrapeHintsSSL(t *testing.T) {
+	server, queries := recordQueries(t, "proxyscrape/https.txt")
+	defer server.Close()
+
+	hints := providers.Hints{Schemes: []string{"http", "https"}, Anonymity: providers.AnonymityElite, SSL: true}
+	provider := providers.NewProxyScrape(server.URL, fixtureEngine())
+
+	ps, _, err := providers.FetchWithHints("ProxyScrape", provider, 5*time.Second, hints)
+	assert.Nil(t, err)
+
+	for _, p := range ps {
+		assert.Equal(t, "http", p.URL.Scheme)
+		assert.True(t, p.Can(providers.CapabilityConnect))
+	}
+
+	assert.Equal(t, []string{
+		"/?anonymity=elite&country=all&proxytype=http&request=getproxies&ssl=yes&timeout=10000",
+	}, queries())
+}
+
+// TestGetProxyListHints tests that the GetProxyList provider turns the hints it is given into API parameters.
+func TestGetProxyListHints(t *testing.T) {
+	server, queries := recordQueries(t, "getproxylist/proxy.json")
+	defer server.Close()
+
+	engine := fixtureEngine()
+	engine.MaxConcurrency = 1
+
+	hints := providers.Hints{
+		Countries: []string{"US"},
+		Schemes:   []string{"https", "socks5"},
+		Anonymity: providers.AnonymityElite,
+	}
+
+	provider := providers.NewGetProxyList(server.URL, engine)
+	providers.FetchWithHints("GetProxyList", provider, 100*time.Millisecond, hints)
+
+	requested := queries()
+	if assert.NotEmpty(t, requested) {
+		u, err := url.Parse(requested[0])
+		assert.Nil(t, err)
+
+		assert.Equal(t, url.Values{
+			"country[]":   {"US"},
+			"protocol[]":  {"http", "socks5"},
+			"anonymity[]": {"high anonymity"},
+			"allowsHttps": {"1"},
+		}, u.Query())
+	}
+}
+
+// TestFreeProxyListsHints tests that the FreeProxyLists provider only scrapes the lists matching its hints, and
+// makes no requests at all for schemes it doesn't have.
+func TestFreeProxyListsHints(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		requested []string
+	)
+
+	files := http.FileServer(http.Dir("testdata/freeproxylists"))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, r.URL.Path)
+		mu.Unlock()
+
+		files.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	provider := providers.NewFreeProxyLists(server.URL, fixtureEngine())
+
+	hints := providers.Hints{Anonymity: providers.AnonymityElite}
+	_, _, err := providers.FetchWithHints("FreeProxyLists", provider, 5*time.Second, hints)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/elite.html", "/load_elite_d1.html"}, requested)
+
+	requested = nil
+
+	hints = providers.Hints{Schemes: []string{"socks5"}}
+	_, _, err = providers.FetchWithHints("FreeProxyLists", provider, 5*time.Second, hints)
+	assert.NotNil(t, err)
+	assert.Empty(t, requested)
+}
+
+// TestProxyScrapeIPv6 tests that the ProxyScrape provider accepts IPv6 proxies.
+func TestProxyScrapeIPv6(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("[2001:4860:4860::8888]:3128\r\n1.1.1.1:80\r\n"))
+	}))
+	defer server.Close()
+
+	hints := providers.Hints{Schemes: []string{"socks5"}}
+	provider := providers.NewProxyScrape(server.URL, fixtureEngine())
+
+	ps, _, err := providers.FetchWithHints("ProxyScrape", provider, 5*time.Second, hints)
+	assert.Nil(t, err)
+
+	countries := map[string]string{}
+	for _, p := range ps {
+		countries[p.URL.String()] = p.Country
+	}
+
+	assert.Equal(t, map[string]string{
+		"socks5://[2001:4860:4860::8888]:3128": "US",
+		"socks5://1.1.1.1:80":                  "AU",
+	}, countries)
+}
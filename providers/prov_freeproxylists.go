@@ -1,16 +1,15 @@
 package providers
 
 import (
+	"bytes"
 	"encoding/xml"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"net/http"
+	"net"
 	"regexp"
 	"strings"
-	"time"
 
-	"github.com/gocolly/colly"
+	"github.com/PuerkitoBio/goquery"
 )
 
 type freeProxyListsResponse struct {
@@ -23,147 +22,169 @@ type freeProxyListsResponse struct {
 	} `xml:"quote"`
 }
 
-// findLinks will return the links to resources where the anchor text matches the regex.
-func findLinks(url, regex string) (links []string) {
-	c := colly.NewCollector()
+// findLinks returns the links in the HTML document given whose anchor text matches the regex.
+func findLinks(body []byte, regex string) ([]string, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
 
-	c.OnHTML("a", func(e *colly.HTMLElement) {
-		regex := regexp.MustCompile(regex)
+	re := regexp.MustCompile(regex)
+	links := []string{}
 
-		if regex.Match([]byte(e.Text)) {
-			links = append(links, e.Attr("href"))
+	doc.Find("a").Each(func(i int, s *goquery.Selection) {
+		if re.MatchString(s.Text()) {
+			links = append(links, s.AttrOr("href", ""))
 		}
-
 	})
 
-	c.Visit(url)
+	return links, nil
+}
+
+// freeProxyListsResource returns the URL of the XML data behind a link to a detailed list.
+func freeProxyListsResource(baseURL, link string) (string, error) {
+	components := strings.Split(link, "/")
+	if len(components) != 2 {
+		return "", fmt.Errorf("providers (FreeProxyLists): invalid link type %v", link)
+	}
+
+	ptype, id := components[0], components[1]
 
-	return links
+	return fmt.Sprintf("%v/load_%v_%v", baseURL, ptype, id), nil
 }
 
-func freeProxyListsWorker(id int, client *http.Client, jobs <-chan string, results chan<- Proxy) {
-	for link := range jobs {
-		components := strings.Split(link, "/")
+// freeProxyListsParse adds the proxies in a FreeProxyLists XML resource to the set.
+func freeProxyListsParse(proxies *Set, raw []byte) {
+	body := string(raw)
+
+	body = strings.ReplaceAll(body, "&lt;", "<")
+	body = strings.ReplaceAll(body, "&gt;", ">")
+
+	table := freeProxyListsResponse{}
+
+	err := xml.Unmarshal([]byte(body), &table)
+	if err != nil && err != io.EOF {
+		logger.Errorf("providers (FreeProxyLists): could not unmarshal xml response: %v", err)
+		proxies.Reject(RejectParseFailure)
+		return
+	}
+
+	for _, row := range table.Quote.Table.Tr {
 
-		if len(components) != 2 {
-			logger.Errorf("providers (FreeProxyLists): invalid link type %v", link)
+		if len(row.Td) != 6 {
+			proxies.Reject(RejectParseFailure)
 			continue
 		}
 
-		id := components[1]
-		ptype := components[0]
+		// Proxies which support SSL are still HTTP proxies, which can tunnel HTTPS connections with CONNECT.
+		var capabilities Capability
+		if strings.TrimSpace(row.Td[2]) == "true" {
+			capabilities = CapabilityConnect
+		}
 
-		resource := fmt.Sprintf("http://freeproxylists.com/load_%v_%v", ptype, id)
-		resp, err := client.Get(resource)
+		u, err := ParseAddress(net.JoinHostPort(strings.TrimSpace(row.Td[0]), strings.TrimSpace(row.Td[1])), "http")
 		if err != nil {
-			logger.Debugf("providers (FreeProxyLists): error requesting proxies from site %v: %v", link, err)
+			logger.Debugf("providers (FreeProxyLists): %v", err)
+			proxies.Reject(RejectMalformedIP)
 			continue
 		}
 
-		bytes, err := ioutil.ReadAll(resp.Body)
+		country, err := countryInfo.FindCountryByName(row.Td[5])
 		if err != nil {
-			logger.Debugf("providers (FreeProxyLists): cannot read response body from ProxyScrape")
+			logger.Debugf("providers (FreeProxyLists): cannot deduce ISO country code for country '%v': %v", row.Td[5], err)
+			proxies.Reject(RejectBadCountry)
 			continue
 		}
 
-		body := string(bytes)
-
-		body = strings.ReplaceAll(body, "&lt;", "<")
-		body = strings.ReplaceAll(body, "&gt;", ">")
+		proxy := Proxy{URL: u, Provider: "FreeProxyLists", Country: country, Capabilities: capabilities}
+		proxies.Add(proxy)
+	}
+}
 
-		table := freeProxyListsResponse{}
+// freeProxyListsPages returns the pages listing the proxies wanted by the hints given. The site only has HTTP and
+// HTTPS proxies, so nothing is requested if neither is wanted.
+func freeProxyListsPages(baseURL string, hints Hints) []string {
+	if !hints.WantsScheme("http") && !hints.WantsScheme("https") {
+		return []string{}
+	}
 
-		err = xml.Unmarshal([]byte(body), &table)
-		if err != nil && err != io.EOF {
-			logger.Errorf("providers (FreeProxyLists): could not unmarshal xml response: %v", err)
-			continue
-		}
+	if hints.SSL || !hints.WantsScheme("http") {
+		return []string{baseURL + "/https.html"}
+	}
 
-		for _, row := range table.Quote.Table.Tr {
+	switch hints.Anonymity {
+	case AnonymityElite:
+		return []string{baseURL + "/elite.html"}
+	case AnonymityAnonymous:
+		return []string{baseURL + "/anonymous.html"}
+	}
 
-			if len(row.Td) != 6 {
-				continue
-			}
+	return []string{
+		baseURL + "/elite.html",
+		baseURL + "/anonymous.html",
+		baseURL + "/https.html",
+	}
+}
 
-			hasSSL := row.Td[2] == "true"
+// FreeProxyLists returns the proxies that can be found on the site https://freeproxylists.com
+var FreeProxyLists = NewFreeProxyLists("http://freeproxylists.com", nil)
 
-			var protocol string
+// NewFreeProxyLists creates a FreeProxyLists provider which scrapes the site at baseURL using the engine given. If
+// the engine is nil, DefaultEngine is used. The schemes, anonymity and SSL hints in the request decide which of
+// the site's lists are scraped.
+func NewFreeProxyLists(baseURL string, engine *Engine) Provider {
+	return func(proxies *Set, req Request) ([]Proxy, error) {
+		logger.Debug("providers: Fetching proxies from provider FreeProxyLists")
 
-			if hasSSL {
-				protocol = "https"
-			} else {
-				protocol = "http"
-			}
+		ctx, cancel := req.context()
+		defer cancel()
 
-			rawip := fmt.Sprintf("%v://%v:%v", protocol, row.Td[0], row.Td[1])
+		lists := freeProxyListsPages(baseURL, req.Hints)
 
-			country, err := countryInfo.FindCountryByName(row.Td[5])
+		engineOrDefault(engine).GetAll(ctx, lists, func(list string, body []byte, err error) {
 			if err != nil {
-				logger.Debugf("providers (FreeProxyLists): cannot deduce ISO country code for country '%v': %v", row.Td[5], err)
-				continue
+				logger.Debugf("providers (FreeProxyLists): error requesting proxy lists from site %v: %v", list, err)
+				proxies.HTTPError()
+				return
 			}
 
-			proxy, err := newProxy(rawip, "FreeProxyLists", country)
+			logger.Debugf("providers (FreeProxyLists): Pulling proxy lists from site %v", list)
+
+			links, err := findLinks(body, `^detailed list #\d+`)
 			if err != nil {
-				logger.Debugf("providers (FreeProxyLists): cannot create new proxy: %v", err)
-				continue
+				logger.Debugf("providers (FreeProxyLists): cannot parse proxy lists from site %v: %v", list, err)
+				proxies.Reject(RejectParseFailure)
+				return
 			}
 
-			results <- proxy
-		}
-	}
-}
-
-// FreeProxyLists returns the proxies that can be found on the site https://freeproxylists.com
-func FreeProxyLists(proxies *Set, timeout time.Duration) ([]Proxy, error) {
-	logger.Debug("providers: Fetching proxies from provider FreeProxyLists")
-	client := &http.Client{}
-
-	var lists = []string{
-		"http://freeproxylists.com/elite.html",
-		"http://freeproxylists.com/anonymous.html",
-		"http://freeproxylists.com/https.html",
-	}
-
-	jobs := make(chan string, 100)
-	results := make(chan Proxy, 100)
+			resources := []string{}
+			for _, link := range links {
+				resource, err := freeProxyListsResource(baseURL, link)
+				if err != nil {
+					logger.Debug(err)
+					proxies.Reject(RejectParseFailure)
+					continue
+				}
 
-	for _, list := range lists {
-		logger.Debugf("providers (FreeProxyLists): Pulling proxy lists from site %v", list)
-
-		links := findLinks(list, `^detailed list #\d+`)
-
-		for i := 0; i < 50; i++ {
-			go freeProxyListsWorker(i, client, jobs, results)
-		}
+				resources = append(resources, resource)
+			}
 
-		for _, link := range links {
-			jobs <- link
-		}
-	}
-	close(jobs)
+			engineOrDefault(engine).GetAll(ctx, resources, func(resource string, body []byte, err error) {
+				if err != nil {
+					logger.Debugf("providers (FreeProxyLists): error requesting proxies from site %v: %v", resource, err)
+					proxies.HTTPError()
+					return
+				}
 
-	tchan := make(chan struct{})
+				freeProxyListsParse(proxies, body)
+			})
+		})
 
-	go func() {
-		for proxy := range results {
-			proxies.Add(proxy)
+		ps := proxies.List()
+		if len(ps) == 0 {
+			return ps, fmt.Errorf("providers (FreeProxyLists): no proxies could be gathered")
 		}
 
-		tchan <- struct{}{}
-	}()
-
-	select {
-	case <-tchan:
-		break
-	case <-time.After(timeout):
-		break
+		return ps, nil
 	}
-
-	ps := proxies.List()
-	if len(ps) == 0 {
-		return ps, fmt.Errorf("providers (FreeProxyLists): no proxies could be gathered")
-	}
-
-	return ps, nil
 }
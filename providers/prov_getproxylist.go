@@ -1,84 +1,148 @@
 package providers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"net/http"
-	"time"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
 )
 
 type getProxyListResponse struct {
-	IP       string `json:"ip"`
-	Port     int    `json:"port"`
-	Protocol string `json:"protocol"`
-	Country  string `json:"country"`
+	IP          string `json:"ip"`
+	Port        int    `json:"port"`
+	Protocol    string `json:"protocol"`
+	Country     string `json:"country"`
+	AllowsHTTPS bool   `json:"allowsHttps"`
 }
 
-func getProxyListWorker(id int, num int, client *http.Client, results chan Proxy) {
-	for i := 0; i < num; i++ {
-		resp, err := client.Get("https://api.getproxylist.com/proxy")
-		if err != nil {
-			logger.Debugf("providers (GetProxyList): cannot request GetProxyList endpoint: %v", err)
-			return
-		}
+// getProxyListRequests is the most requests made to the GetProxyList API in a single fetch, since each request
+// only returns one proxy.
+const getProxyListRequests = 2500
 
-		bytes, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			logger.Debugf("providers (GetProxyList): cannot read response body from GetProxyList: %v", err)
-			continue
-		}
+// GetProxyList returns the proxies that can be found on the site https://api.getproxylist.com/proxy.
+var GetProxyList = NewGetProxyList("https://api.getproxylist.com", nil)
 
-		response := &getProxyListResponse{}
+// getProxyListAnonymity maps the anonymity hints to the levels used by the GetProxyList API.
+var getProxyListAnonymity = map[string]string{
+	AnonymityTransparent: "transparent",
+	AnonymityAnonymous:   "anonymous",
+	AnonymityElite:       "high anonymity",
+}
 
-		err = json.Unmarshal(bytes, response)
-		if err != nil {
-			logger.Debugf("providers (GetProxyList): could not unmarshal api response")
-			continue
-		}
+// getProxyListEndpoint returns the API endpoint to request for the hints given.
+func getProxyListEndpoint(baseURL string, hints Hints) string {
+	query := url.Values{}
 
-		proxy, err := newProxy(response.IP, "GetProxyList", response.Country)
-		if err != nil {
-			logger.Debugf("providers (GetProxyList): cannot create new proxy: %v", err)
-			continue
+	for _, country := range hints.Countries {
+		query.Add("country[]", country)
+	}
+
+	if len(hints.Schemes) != 0 {
+		if hints.WantsScheme("http") || hints.WantsScheme("https") {
+			query.Add("protocol[]", "http")
 		}
 
-		results <- proxy
+		for _, scheme := range []string{"socks4", "socks5"} {
+			if hints.WantsScheme(scheme) {
+				query.Add("protocol[]", scheme)
+			}
+		}
 	}
-}
 
-// GetProxyList returns the proxies that can be found on the site https://api.getproxylist.com/proxy.
-func GetProxyList(proxies *Set, timeout time.Duration) ([]Proxy, error) {
-	logger.Debug("providers: Fetching proxies from provider GetProxyList")
-	client := &http.Client{}
+	if level, ok := getProxyListAnonymity[hints.Anonymity]; ok {
+		query.Add("anonymity[]", level)
+	}
 
-	results := make(chan Proxy, 100)
+	// The API has no HTTPS protocol, only whether a proxy supports it.
+	if hints.SSL || (hints.WantsScheme("https") && !hints.WantsScheme("http")) {
+		query.Set("allowsHttps", "1")
+	}
 
-	for i := 0; i < 100; i++ {
-		go getProxyListWorker(i, 50*50, client, results)
+	if len(query) == 0 {
+		return baseURL + "/proxy"
 	}
 
-	tchan := make(chan struct{})
+	return baseURL + "/proxy?" + query.Encode()
+}
 
-	go func() {
-		for proxy := range results {
-			proxies.Add(proxy)
-		}
+// NewGetProxyList creates a GetProxyList provider which requests the API at baseURL using the engine given. If the
+// engine is nil, DefaultEngine is used. The countries, schemes, anonymity and SSL hints in the request are passed
+// on to the API. Proxies listed without a protocol are probed with DefaultProber. Requests are made within the
+// engine's concurrency limit, and no more are started once one fails, but proxies from requests already made are
+// kept.
+func NewGetProxyList(baseURL string, engine *Engine) Provider {
+	return func(proxies *Set, req Request) ([]Proxy, error) {
+		logger.Debug("providers: Fetching proxies from provider GetProxyList")
 
-		tchan <- struct{}{}
-	}()
+		ctx, cancel := req.context()
+		defer cancel()
 
-	select {
-	case <-tchan:
-		break
-	case <-time.After(timeout):
-		break
-	}
+		endpoint := getProxyListEndpoint(baseURL, req.Hints)
 
-	ps := proxies.List()
-	if len(ps) == 0 {
-		return ps, fmt.Errorf("providers (GetProxyList): no proxies could be gathered")
-	}
+		urls := make([]string, getProxyListRequests)
+		for i := range urls {
+			urls[i] = endpoint
+		}
 
-	return ps, nil
+		// Probing uses its own context, so that proxies found just before the API stops responding can still be
+		// probed.
+		probeCtx, cancelProbes := context.WithTimeout(context.Background(), req.Timeout)
+		defer cancelProbes()
+
+		detecting := newDetectingSet(probeCtx, proxies, req.prober())
+
+		engineOrDefault(engine).getEach(ctx, urls, func(link string, body []byte, err error) bool {
+			if err != nil {
+				// The API is rate limiting us or is down, so further requests are pointless. Requests already in
+				// flight are left to finish, so the proxies they find aren't lost.
+				logger.Debugf("providers (GetProxyList): cannot request GetProxyList endpoint, stopping: %v", err)
+				proxies.HTTPError()
+				return false
+			}
+
+			response := &getProxyListResponse{}
+
+			err = json.Unmarshal(body, response)
+			if err != nil {
+				logger.Debugf("providers (GetProxyList): could not unmarshal api response")
+				proxies.Reject(RejectParseFailure)
+				return true
+			}
+
+			address := net.JoinHostPort(response.IP, strconv.Itoa(response.Port))
+
+			// Some proxies are listed without a protocol, so they have to be probed to find out what they speak.
+			scheme := strings.ToLower(response.Protocol)
+			if scheme == "" {
+				scheme = SchemeDetect
+			}
+
+			u, err := parseDetectableAddress(address, scheme)
+			if err != nil {
+				logger.Debugf("providers (GetProxyList): %v", err)
+				proxies.Reject(RejectMalformedIP)
+				return true
+			}
+
+			proxy := Proxy{URL: u, Provider: "GetProxyList", Country: response.Country}
+			if response.AllowsHTTPS {
+				proxy.Capabilities = CapabilityConnect
+			}
+
+			detecting.Add(proxy)
+			return true
+		})
+
+		detecting.Wait()
+
+		ps := proxies.List()
+		if len(ps) == 0 {
+			return ps, fmt.Errorf("providers (GetProxyList): no proxies could be gathered")
+		}
+
+		return ps, nil
+	}
 }
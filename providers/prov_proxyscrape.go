@@ -2,110 +2,130 @@ package providers
 
 import (
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"net/url"
 	"strings"
-	"time"
 )
 
-func proxyScrapeWorker(id int, client *http.Client, jobs chan [2]string, results chan Proxy) {
-	for job := range jobs {
-		ptype, link := job[0], job[1]
+// proxyScrapeParse adds the proxies in a ProxyScrape API response to the set. The API lists HTTP proxies which
+// support SSL as "https", but they are ordinary HTTP proxies which can tunnel connections, so they are given the
+// scheme "http" and CapabilityConnect.
+func proxyScrapeParse(proxies *Set, ptype string, body []byte) {
+	var capabilities Capability
+	if ptype == "https" {
+		ptype, capabilities = "http", CapabilityConnect
+	}
+
+	for _, line := range splitLines(body) {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
 
-		resp, err := client.Get(link)
+		u, err := ParseAddress(line, ptype)
 		if err != nil {
-			logger.Debugf("providers (ProxyScrape): cannot request ProxyScrape API endpoint %v: %v", link, err)
+			logger.Debugf("providers (ProxyScrape): %v", err)
+			proxies.Reject(RejectMalformedIP)
 			continue
 		}
 
-		bytes, err := ioutil.ReadAll(resp.Body)
+		country, err := countryInfo.FindCountryByIP(u.Hostname())
 		if err != nil {
-			logger.Debugf("providers (ProxyScrape): cannot read response body from ProxyScrape")
+			logger.Debugf("providers (ProxyScrape): cannot find country from ip '%v': %v", u.Hostname(), err)
+			proxies.Reject(RejectBadCountry)
 			continue
 		}
 
-		lines := strings.Split(strings.TrimSpace(string(bytes)), "\n")
+		proxies.Add(Proxy{URL: u, Provider: "ProxyScrape", Country: country, Capabilities: capabilities})
+	}
+}
 
-		for _, rawip := range lines {
+// proxyScrapeLinks returns the API endpoints to request for the hints given, mapped to the type of proxies each
+// one returns: "http", "https" for HTTP proxies which support SSL, "socks4" or "socks5". A request is made for
+// each wanted scheme and country.
+func proxyScrapeLinks(baseURL string, hints Hints) map[string]string {
+	countries := hints.Countries
+	if len(countries) == 0 {
+		countries = []string{"all"}
+	}
 
-			rawip = strings.TrimSpace(rawip)
-			ip := ptype + "://" + rawip
+	anonymity := hints.Anonymity
+	if anonymity == "" {
+		anonymity = "all"
+	}
 
-			if strings.Count(rawip, ".") != 3 {
-				logger.Debugf("providers (ProxyScrape): malformed proxy ip %v", rawip)
-				continue
-			}
+	links := map[string]string{}
 
-			components := strings.Split(rawip, ":")
-			if len(components) != 2 {
-				logger.Debugf("providers (ProxyScrape): invalid proxy ip %v", rawip)
-				continue
-			}
+	for _, scheme := range []string{"http", "https", "socks4", "socks5"} {
+		if !hints.WantsScheme(scheme) {
+			continue
+		}
 
-			country, err := countryInfo.FindCountryByIP(components[0])
-			if err != nil {
-				logger.Debugf("providers (ProxyScrape): cannot find country from ip '%v': %v", components[0], err)
-				continue
-			}
+		// ProxyScrape only says whether HTTP proxies support SSL, so asking for SSL rules out plain HTTP ones.
+		if scheme == "http" && hints.SSL {
+			continue
+		}
 
-			proxy, err := newProxy(ip, "ProxyScrape", country)
-			if err != nil {
-				logger.Debugf("providers (ProxyScrape): cannot create new proxy: %v", err)
-				continue
+		for _, country := range countries {
+			query := url.Values{}
+			query.Set("request", "getproxies")
+			query.Set("timeout", "10000")
+			query.Set("country", country)
+
+			switch scheme {
+			case "http", "https":
+				query.Set("proxytype", "http")
+				query.Set("anonymity", anonymity)
+
+				if scheme == "https" {
+					query.Set("ssl", "yes")
+				} else {
+					query.Set("ssl", "no")
+				}
+			default:
+				query.Set("proxytype", scheme)
 			}
 
-			results <- proxy
-
+			links[baseURL+"/?"+query.Encode()] = scheme
 		}
 	}
+
+	return links
 }
 
 // ProxyScrape returns the proxies that can be found on the site https://proxyscrape.com.
-func ProxyScrape(proxies *Set, timeout time.Duration) ([]Proxy, error) {
-	logger.Debug("providers: Fetching proxies from provider ProxyScrape")
-	client := &http.Client{Timeout: timeout}
-
-	var links = map[string]string{
-		"http":   "https://api.proxyscrape.com/?request=getproxies&proxytype=all&timeout=10000&country=all&ssl=no&anonymity=all",
-		"https":  "https://api.proxyscrape.com/?request=getproxies&proxytype=all&timeout=10000&country=all&ssl=yes&anonymity=all",
-		"socks4": "https://api.proxyscrape.com/?request=getproxies&proxytype=socks4&timeout=10000&country=all",
-		"socks5": "https://api.proxyscrape.com/?request=getproxies&proxytype=socks5&timeout=10000&country=all",
-	}
-
-	jobs := make(chan [2]string, 100)
-	results := make(chan Proxy, 100)
+var ProxyScrape = NewProxyScrape("https://api.proxyscrape.com", nil)
 
-	for i := 0; i < 25; i++ {
-		go proxyScrapeWorker(i, client, jobs, results)
-	}
+// NewProxyScrape creates a ProxyScrape provider which requests the API at baseURL using the engine given. If the
+// engine is nil, DefaultEngine is used. The countries, schemes, anonymity and SSL hints in the request are passed on
+// to the API.
+func NewProxyScrape(baseURL string, engine *Engine) Provider {
+	return func(proxies *Set, req Request) ([]Proxy, error) {
+		logger.Debug("providers: Fetching proxies from provider ProxyScrape")
 
-	for ptype, link := range links {
-		jobs <- [2]string{ptype, link}
-	}
-	close(jobs)
+		ctx, cancel := req.context()
+		defer cancel()
 
-	tchan := make(chan struct{})
+		links := proxyScrapeLinks(baseURL, req.Hints)
 
-	go func() {
-		for proxy := range results {
-			proxies.Add(proxy)
+		urls := []string{}
+		for link := range links {
+			urls = append(urls, link)
 		}
 
-		tchan <- struct{}{}
-	}()
+		engineOrDefault(engine).GetAll(ctx, urls, func(link string, body []byte, err error) {
+			if err != nil {
+				logger.Debugf("providers (ProxyScrape): cannot request ProxyScrape API endpoint %v: %v", link, err)
+				proxies.HTTPError()
+				return
+			}
 
-	select {
-	case <-tchan:
-		break
-	case <-time.After(timeout):
-		break
-	}
+			proxyScrapeParse(proxies, links[link], body)
+		})
 
-	ps := proxies.List()
-	if len(ps) == 0 {
-		return ps, fmt.Errorf("providers (ProxyScrape): no proxies could be gathered")
-	}
-
-	return ps, nil
+		ps := proxies.List()
+		if len(ps) == 0 {
+			return ps, fmt.Errorf("providers (ProxyScrape): no proxies could be gathered")
+		}
 
+		return ps, nil
+	}
 }
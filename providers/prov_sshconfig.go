@@ -0,0 +1,338 @@
+package providers
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SSHHost holds the options of a Host block in an ssh_config file which prox uses to connect to SSH servers.
+// Other options are ignored.
+type SSHHost struct {
+	// Patterns are the patterns after the Host keyword, such as "bastion", "*.example.com" or "!internal".
+	Patterns []string
+
+	HostName string
+	User     string
+	Port     string
+
+	// IdentityFiles are the private keys to authenticate with, with any leading ~ expanded.
+	IdentityFiles []string
+
+	// KnownHostsFiles are the files given by UserKnownHostsFile, with any leading ~ expanded.
+	KnownHostsFiles []string
+}
+
+// Matches returns true if the block applies to host: if any of its patterns match it, and none of its negated
+// patterns do.
+func (h SSHHost) Matches(host string) bool {
+	matched := false
+
+	for _, pattern := range h.Patterns {
+		if strings.HasPrefix(pattern, "!") {
+			if matchSSHPattern(pattern[1:], host) {
+				return false
+			}
+
+			continue
+		}
+
+		matched = matched || matchSSHPattern(pattern, host)
+	}
+
+	return matched
+}
+
+// matchSSHPattern returns true if host matches an ssh_config pattern, where * matches any amount of characters
+// and ? matches exactly one. Hostnames are matched ignoring case.
+func matchSSHPattern(pattern, host string) bool {
+	expr := regexp.QuoteMeta(strings.ToLower(pattern))
+	expr = strings.Replace(expr, `\*`, ".*", -1)
+	expr = strings.Replace(expr, `\?`, ".", -1)
+
+	matched, err := regexp.MatchString("^"+expr+"$", strings.ToLower(host))
+	return err == nil && matched
+}
+
+// ParseSSHConfig reads the Host blocks in an ssh_config file. Options before the first Host block are returned as
+// a block with the pattern "*", since they apply to every host. Match blocks and Include directives aren't
+// supported, and are skipped.
+func ParseSSHConfig(r io.Reader) ([]SSHHost, error) {
+	hosts := []SSHHost{}
+
+	global := &SSHHost{Patterns: []string{"*"}}
+	current := global
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		keyword, args, err := splitSSHConfigLine(text)
+		if err != nil {
+			return nil, fmt.Errorf("providers: invalid ssh_config line %d: %v", line, err)
+		}
+
+		if len(args) == 0 {
+			return nil, fmt.Errorf("providers: invalid ssh_config line %d: %v has no value", line, keyword)
+		}
+
+		switch keyword {
+		case "host":
+			if current != nil && current != global {
+				hosts = append(hosts, *current)
+			}
+
+			current = &SSHHost{Patterns: args}
+		case "match":
+			if current != nil && current != global {
+				hosts = append(hosts, *current)
+			}
+
+			current = nil
+		case "include":
+			logger.Debugf("providers: skipping ssh_config include on line %d", line)
+		}
+
+		if current == nil {
+			continue
+		}
+
+		// Like ssh, the first value given for an option is the one used.
+		switch keyword {
+		case "hostname":
+			if current.HostName == "" {
+				current.HostName = args[0]
+			}
+		case "user":
+			if current.User == "" {
+				current.User = args[0]
+			}
+		case "port":
+			if current.Port == "" {
+				current.Port = args[0]
+			}
+		case "identityfile":
+			current.IdentityFiles = append(current.IdentityFiles, expandHome(args[0]))
+		case "userknownhostsfile":
+			if len(current.KnownHostsFiles) == 0 {
+				for _, arg := range args {
+					current.KnownHostsFiles = append(current.KnownHostsFiles, expandHome(arg))
+				}
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "providers: cannot read ssh_config")
+	}
+
+	if current != nil && current != global {
+		hosts = append(hosts, *current)
+	}
+
+	if global.HostName != "" || global.User != "" || global.Port != "" || len(global.IdentityFiles) != 0 ||
+		len(global.KnownHostsFiles) != 0 {
+		hosts = append([]SSHHost{*global}, hosts...)
+	}
+
+	return hosts, nil
+}
+
+// splitSSHConfigLine splits a line of an ssh_config file into its lowercase keyword and its arguments. The
+// keyword can be separated from the arguments by whitespace or an =, and arguments can be quoted.
+func splitSSHConfigLine(line string) (string, []string, error) {
+	end := strings.IndexAny(line, " \t=")
+	if end == -1 {
+		return strings.ToLower(line), nil, nil
+	}
+
+	keyword, rest := strings.ToLower(line[:end]), strings.TrimSpace(line[end:])
+	rest = strings.TrimSpace(strings.TrimPrefix(rest, "="))
+
+	args := []string{}
+	for rest != "" {
+		if rest[0] == '"' {
+			closing := strings.Index(rest[1:], `"`)
+			if closing == -1 {
+				return "", nil, fmt.Errorf("unterminated quote")
+			}
+
+			args = append(args, rest[1:closing+1])
+			rest = strings.TrimSpace(rest[closing+2:])
+			continue
+		}
+
+		end := strings.IndexAny(rest, " \t")
+		if end == -1 {
+			end = len(rest)
+		}
+
+		args = append(args, rest[:end])
+		rest = strings.TrimSpace(rest[end:])
+	}
+
+	return keyword, args, nil
+}
+
+// expandHome replaces a leading ~ in path with the current user's home directory.
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+
+	return filepath.Join(home, path[1:])
+}
+
+// LookupSSHHost returns the options which apply to host, combining every block which matches it in order. As in
+// ssh, the first value found for an option is used, apart from identity files, which are all kept. A block also
+// applies if its HostName is host, so that options can be found again after an alias has been resolved. Options
+// missing from every matching block are left empty.
+func LookupSSHHost(hosts []SSHHost, host string) SSHHost {
+	result := SSHHost{Patterns: []string{host}}
+
+	for _, h := range hosts {
+		if !h.Matches(host) && !strings.EqualFold(h.HostName, host) {
+			continue
+		}
+
+		if result.HostName == "" {
+			result.HostName = h.HostName
+		}
+
+		if result.User == "" {
+			result.User = h.User
+		}
+
+		if result.Port == "" {
+			result.Port = h.Port
+		}
+
+		if len(result.KnownHostsFiles) == 0 {
+			result.KnownHostsFiles = h.KnownHostsFiles
+		}
+
+		result.IdentityFiles = append(result.IdentityFiles, h.IdentityFiles...)
+	}
+
+	return result
+}
+
+// NewSSHConfigFile creates a provider which reads the ssh_config file at path, and gives a ssh:// proxy for every
+// host named in a Host block. Patterns with wildcards or negations don't name a host, so they only add options to
+// other hosts. The port defaults to 22 and the user to the current user. The file is read again every time the
+// provider is used.
+func NewSSHConfigFile(path string) Provider {
+	return func(proxies *Set, req Request) ([]Proxy, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return []Proxy{}, errors.Wrap(err, "providers (SSHConfig): cannot read ssh_config")
+		}
+		defer f.Close()
+
+		hosts, err := ParseSSHConfig(f)
+		if err != nil {
+			return []Proxy{}, errors.Wrap(err, "providers (SSHConfig)")
+		}
+
+		for _, alias := range sshAliases(hosts) {
+			proxy, err := sshProxy(LookupSSHHost(hosts, alias), alias)
+			if err != nil {
+				logger.Debugf("providers (SSHConfig): %v", err)
+				proxies.Reject(RejectMalformedIP)
+				continue
+			}
+
+			proxies.Add(proxy)
+		}
+
+		ps := proxies.List()
+		if len(ps) == 0 {
+			return ps, fmt.Errorf("providers (SSHConfig): no proxies could be gathered")
+		}
+
+		return ps, nil
+	}
+}
+
+// sshAliases returns the hosts named by the Host blocks given, in order and without duplicates.
+func sshAliases(hosts []SSHHost) []string {
+	aliases := []string{}
+	seen := map[string]bool{}
+
+	for _, h := range hosts {
+		for _, pattern := range h.Patterns {
+			if strings.ContainsAny(pattern, "*?!") || seen[strings.ToLower(pattern)] {
+				continue
+			}
+
+			seen[strings.ToLower(pattern)] = true
+			aliases = append(aliases, pattern)
+		}
+	}
+
+	return aliases
+}
+
+// sshProxy creates the ssh:// proxy for the options of the host alias given. The country is only looked up if the
+// host is an IP address, since SSH servers are usually named.
+func sshProxy(h SSHHost, alias string) (Proxy, error) {
+	host, port, username := h.HostName, h.Port, h.User
+	if host == "" {
+		host = alias
+	}
+
+	if port == "" {
+		port = "22"
+	}
+
+	if username == "" {
+		username = currentUser()
+	}
+
+	address := "ssh://" + net.JoinHostPort(host, port)
+	if username != "" {
+		address = "ssh://" + url.User(username).String() + "@" + net.JoinHostPort(host, port)
+	}
+
+	u, err := ParseAddress(address, "ssh")
+	if err != nil {
+		return Proxy{}, err
+	}
+
+	proxy := Proxy{URL: u, Provider: "SSHConfig"}
+
+	if net.ParseIP(u.Hostname()) != nil {
+		proxy.Country, err = countryInfo.FindCountryByIP(u.Hostname())
+		if err != nil {
+			logger.Debugf("providers (SSHConfig): cannot find country for %v: %v", alias, err)
+		}
+	}
+
+	return proxy, nil
+}
+
+// currentUser returns the name of the user running prox, or an empty string if it can't be found.
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+
+	return os.Getenv("USER")
+}
@@ -0,0 +1,106 @@
+package providers_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ollybritton/prox/providers"
+	"github.com/stretchr/testify/assert"
+)
+
+const sshConfig = `# bastions
+User ops
+IdentityFile ~/.ssh/id_global
+
+Host bastion-eu bastion-eu-backup
+    HostName 1.1.1.1
+    Port 2222
+    IdentityFile "/keys/eu key"
+
+Host bastion-us
+    HostName=Bastion.US.Example.com
+    User deploy
+
+Host *.internal !db.internal
+    User internal
+    UserKnownHostsFile /etc/ssh/internal_hosts ~/.ssh/internal_hosts
+
+Match host bastion-us
+    User ignored
+
+Host app.internal db.internal
+    Port 2200
+
+Host *
+    Port 22
+    IdentityFile /keys/fallback
+`
+
+// TestParseSSHConfig tests that Host blocks are read, and that the options for a host are combined like ssh does.
+func TestParseSSHConfig(t *testing.T) {
+	hosts, err := providers.ParseSSHConfig(strings.NewReader(sshConfig))
+	assert.Nil(t, err)
+	assert.Len(t, hosts, 6)
+
+	home, _ := os.UserHomeDir()
+
+	eu := providers.LookupSSHHost(hosts, "bastion-eu-backup")
+	assert.Equal(t, "1.1.1.1", eu.HostName)
+	assert.Equal(t, "ops", eu.User)
+	assert.Equal(t, "2222", eu.Port)
+	assert.Equal(t, []string{filepath.Join(home, ".ssh/id_global"), "/keys/eu key", "/keys/fallback"}, eu.IdentityFiles)
+
+	us := providers.LookupSSHHost(hosts, "bastion-us")
+	assert.Equal(t, "Bastion.US.Example.com", us.HostName)
+	assert.Equal(t, "ops", us.User, "options before the first Host apply first")
+	assert.Equal(t, "22", us.Port)
+
+	assert.Equal(t, "1.1.1.1", providers.LookupSSHHost(hosts, "1.1.1.1").HostName, "hosts can be found by their HostName")
+
+	app := providers.LookupSSHHost(hosts, "APP.internal")
+	assert.Equal(t, "2200", app.Port)
+	assert.Equal(t, []string{"/etc/ssh/internal_hosts", filepath.Join(home, ".ssh/internal_hosts")}, app.KnownHostsFiles)
+
+	db := providers.LookupSSHHost(hosts, "db.internal")
+	assert.Nil(t, db.KnownHostsFiles, "negated patterns should stop a block matching")
+
+	_, err = providers.ParseSSHConfig(strings.NewReader("Host bastion\n  HostName \"1.1.1.1\n"))
+	assert.NotNil(t, err)
+
+	_, err = providers.ParseSSHConfig(strings.NewReader("Host\n"))
+	assert.NotNil(t, err)
+}
+
+// TestSSHConfigFile tests that the provider gives a ssh:// proxy for every host named in the file.
+func TestSSHConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prox-sshconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config")
+	writeFile(t, path, sshConfig)
+
+	ps, report, err := providers.Fetch("SSHConfig", providers.NewSSHConfigFile(path), time.Second)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{
+		"ssh://ops@1.1.1.1:2222",
+		"ssh://ops@bastion.us.example.com:22",
+		"ssh://ops@app.internal:2200",
+		"ssh://ops@db.internal:2200",
+	}, proxyURLs(ps))
+	assert.Equal(t, 1, report.Duplicate, "aliases for the same host should only be stored once")
+
+	for _, p := range ps {
+		assert.Equal(t, "SSHConfig", p.Provider)
+		assert.True(t, p.Can(providers.CapabilityConnect|providers.CapabilityAuth))
+	}
+
+	_, _, err = providers.Fetch("SSHConfig", providers.NewSSHConfigFile(filepath.Join(dir, "missing")), time.Second)
+	assert.NotNil(t, err)
+}
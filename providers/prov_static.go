@@ -3,40 +3,18 @@
 package providers
 
 import (
+	"context"
 	"fmt"
-	"strings"
-	"time"
 )
 
 // Static provides access to a static proxy list that can be used offline.
-func Static(proxies *Set, timeout time.Duration) ([]Proxy, error) {
+func Static(proxies *Set, req Request) ([]Proxy, error) {
 	bytes, err := Asset("data/proxies.txt")
 	if err != nil {
 		return []Proxy{}, err
 	}
 
-	for _, info := range strings.Split(string(bytes), "\n") {
-		func(info string) {
-
-			components := strings.Split(info, " ")
-			if len(components) != 2 {
-				logger.Debugf("providers (Static): invalid static proxy format %v", info)
-				return
-			}
-
-			url := components[0]
-			country := components[1]
-
-			proxy, err := newProxy(url, "Static", country)
-			if err != nil {
-				logger.Debugf("providers (Static): cannot create new proxy: %v", err)
-				return
-			}
-
-			proxies.Add(proxy)
-
-		}(info)
-	}
+	parseList(context.Background(), DefaultProber, proxies, "Static", "http", splitLines(bytes))
 
 	ps := proxies.List()
 	if len(ps) == 0 {
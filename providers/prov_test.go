@@ -7,10 +7,16 @@ import (
 	"github.com/ollybritton/prox/providers"
 )
 
-func testProvider(name string, provider providers.Provider) func(*testing.T) {
+// testProvider tests a provider against the live site. These tests are skipped with -short, see
+// prov_fixtures_test.go for the offline tests.
+func testProvider(name string, provider providers.Provider, network bool) func(*testing.T) {
 	return func(t *testing.T) {
+		if network && testing.Short() {
+			t.Skip("skipping live provider test in short mode")
+		}
+
 		proxies := providers.NewSet()
-		ps, err := provider(proxies, 10*time.Second)
+		ps, err := provider(proxies, providers.NewRequest(10*time.Second))
 
 		if err != nil {
 			t.Errorf("providers (%v): error occurred when scraping proxies: %v", name, err)
@@ -21,8 +27,8 @@ func testProvider(name string, provider providers.Provider) func(*testing.T) {
 }
 
 func TestProviders(t *testing.T) {
-	t.Run("FreeProxyLists", testProvider("FreeProxyLists", providers.FreeProxyLists))
-	t.Run("ProxyScrape", testProvider("ProxyScrape", providers.ProxyScrape))
-	t.Run("GetProxyList", testProvider("GetProxyList", providers.GetProxyList))
-	t.Run("Static", testProvider("Static", providers.Static))
+	t.Run("FreeProxyLists", testProvider("FreeProxyLists", providers.FreeProxyLists, true))
+	t.Run("ProxyScrape", testProvider("ProxyScrape", providers.ProxyScrape, true))
+	t.Run("GetProxyList", testProvider("GetProxyList", providers.GetProxyList, true))
+	t.Run("Static", testProvider("Static", providers.Static, false))
 }
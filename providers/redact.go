@@ -0,0 +1,80 @@
+package providers
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+)
+
+// redacted replaces each part of a proxy's credentials when it is printed.
+const redacted = "xxxxx"
+
+// RedactURL returns the URL as a string with its username and password replaced, so that it can be printed or
+// logged without leaking the credentials of an authenticated proxy.
+func RedactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+
+	if u.User == nil {
+		return u.String()
+	}
+
+	copied := *u
+
+	if _, ok := u.User.Password(); ok {
+		copied.User = url.UserPassword(redacted, redacted)
+	} else {
+		copied.User = url.User(redacted)
+	}
+
+	return copied.String()
+}
+
+// redactCredentials hides the credentials in text which may contain a proxy address that couldn't be parsed,
+// such as a line from a list, for use in log lines and errors.
+func redactCredentials(text string) string {
+	if u, err := url.Parse(text); err == nil && u.User != nil && u.Host != "" {
+		return RedactURL(u)
+	}
+
+	if at := strings.LastIndex(text, "@"); at != -1 {
+		start := strings.Index(text, "://")
+		if start == -1 || start > at {
+			start = strings.LastIndexAny(text[:at], " \t\"'") + 1
+		} else {
+			start += len("://")
+		}
+
+		return text[:start] + redacted + text[at:]
+	}
+
+	// Lists can also give credentials in the form host:port:user:pass.
+	if fields := strings.Split(text, ":"); len(fields) == 4 && !strings.ContainsAny(text, " \t") {
+		return strings.Join([]string{fields[0], fields[1], redacted, redacted}, ":")
+	}
+
+	return text
+}
+
+// Redacted returns the URL of the proxy as a string, with any credentials replaced.
+func (p Proxy) Redacted() string {
+	return RedactURL(p.URL)
+}
+
+// String returns the URL of the proxy with any credentials replaced, so that proxies can be logged safely.
+func (p Proxy) String() string {
+	return p.Redacted()
+}
+
+// MarshalJSON encodes the proxy with its URL as a string. Any credentials in the URL are replaced, so that
+// exported proxies can be shared without leaking them.
+func (p Proxy) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		URL          string     `json:"url"`
+		Provider     string     `json:"providers"`
+		Country      string     `json:"country"`
+		Capabilities Capability `json:"capabilities,omitempty"`
+		Used         bool
+	}{p.Redacted(), p.Provider, p.Country, p.Capabilities, p.Used})
+}
@@ -0,0 +1,150 @@
+package providers
+
+import (
+	"time"
+)
+
+// RejectReason describes why a provider discarded a row it scraped.
+type RejectReason string
+
+const (
+	// RejectBadCountry means the country of the proxy couldn't be worked out.
+	RejectBadCountry RejectReason = "bad_country"
+
+	// RejectMalformedIP means the address of the proxy was invalid.
+	RejectMalformedIP RejectReason = "malformed_ip"
+
+	// RejectParseFailure means the row couldn't be parsed at all.
+	RejectParseFailure RejectReason = "parse_failure"
+
+	// RejectUnknownProtocol means the proxy had no scheme, and probing it didn't find a protocol it speaks.
+	RejectUnknownProtocol RejectReason = "unknown_protocol"
+
+	// RejectFiltered means the proxy was found, but discarded by a filter.
+	RejectFiltered RejectReason = "filtered"
+)
+
+// Report describes the outcome of a single fetch from a provider.
+type Report struct {
+	Provider string        `json:"provider"`
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration"`
+
+	// Found is the amount of proxies the provider found, including duplicates.
+	Found     int `json:"found"`
+	Unique    int `json:"unique"`
+	Duplicate int `json:"duplicate"`
+
+	Rejected   map[RejectReason]int `json:"rejected"`
+	HTTPErrors int                  `json:"http_errors"`
+
+	// Skipped is true if the provider wasn't fetched at all, for example because its circuit breaker was open.
+	Skipped bool `json:"skipped,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// TotalRejected returns the amount of rows rejected for any reason.
+func (r Report) TotalRejected() int {
+	total := 0
+	for _, n := range r.Rejected {
+		total += n
+	}
+
+	return total
+}
+
+// Reject records that a provider discarded a row for the reason given.
+func (s *Set) Reject(reason RejectReason) {
+	s.m.Lock()
+	s.rejected[reason]++
+	s.m.Unlock()
+}
+
+// HTTPError records that a request made by a provider failed.
+func (s *Set) HTTPError() {
+	s.m.Lock()
+	s.httpErrors++
+	s.m.Unlock()
+}
+
+// Merge adds the proxies in other which are accepted by the function given to the set, or every proxy if accept
+// is nil. The duplicates, rejected rows and HTTP errors recorded by other are added to the set's counts, and
+// proxies which aren't accepted are counted as rejected with RejectFiltered. This lets a provider run another
+// provider with a set of its own without losing its report.
+func (s *Set) Merge(other *Set, accept func(Proxy) bool) {
+	for _, p := range other.List() {
+		if accept != nil && !accept(p) {
+			s.Reject(RejectFiltered)
+			continue
+		}
+
+		s.Add(p)
+	}
+
+	s.MergeCounts(other)
+}
+
+// MergeCounts adds the duplicates, rejected rows and HTTP errors recorded by other to the set's counts, without
+// adding any of its proxies. It is used by providers which change the proxies found by another provider before
+// adding them.
+func (s *Set) MergeCounts(other *Set) {
+	report := other.Report()
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.duplicates += report.Duplicate
+	s.httpErrors += report.HTTPErrors
+
+	for reason, n := range report.Rejected {
+		s.rejected[reason] += n
+	}
+}
+
+// Report returns the counts recorded by the set so far. The provider name, timings and error are left
+// for the caller to fill in; see Fetch.
+func (s *Set) Report() Report {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	rejected := make(map[RejectReason]int, len(s.rejected))
+	for k, v := range s.rejected {
+		rejected[k] = v
+	}
+
+	return Report{
+		Found:      s.added + s.duplicates,
+		Unique:     s.added,
+		Duplicate:  s.duplicates,
+		Rejected:   rejected,
+		HTTPErrors: s.httpErrors,
+	}
+}
+
+// Fetch runs a provider with a new set and returns the proxies it found along with a report of the fetch.
+func Fetch(name string, provider Provider, timeout time.Duration) ([]Proxy, Report, error) {
+	return fetch(name, provider, NewRequest(timeout), NewSet())
+}
+
+func fetch(name string, provider Provider, req Request, set *Set) ([]Proxy, Report, error) {
+	started := time.Now()
+
+	ps, err := provider(set, req)
+
+	report := set.Report()
+	report.Provider = name
+	report.Started = started
+	report.Duration = time.Since(started)
+
+	if err != nil {
+		report.Error = err.Error()
+	}
+
+	logger.Debugf(
+		"providers (%v): found %d proxies (%d unique) in %v, rejected %d rows, %d http errors",
+		name, report.Found, report.Unique, report.Duration, report.TotalRejected(), report.HTTPErrors,
+	)
+
+	return ps, report, err
+}
@@ -0,0 +1,71 @@
+package providers_test
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ollybritton/prox/providers"
+	"github.com/stretchr/testify/assert"
+)
+
+func mustProxy(t *testing.T, rawurl string) providers.Proxy {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return providers.Proxy{URL: u, Provider: "Test", Country: "GB"}
+}
+
+// TestSetDeduplicates tests that proxies with the same URL are only stored once, and that the set counts
+// the duplicates.
+func TestSetDeduplicates(t *testing.T) {
+	set := providers.NewSet()
+
+	set.Add(mustProxy(t, "http://1.1.1.1:80"))
+	set.Add(mustProxy(t, "http://1.1.1.1:80"))
+	set.Add(mustProxy(t, "http://2.2.2.2:80"))
+
+	assert.Equal(t, 2, set.Length())
+	assert.True(t, set.In(mustProxy(t, "http://1.1.1.1:80")))
+
+	set.Remove(mustProxy(t, "http://1.1.1.1:80"))
+	assert.Equal(t, 1, set.Length(), "removing a proxy with the same URL should remove the stored proxy")
+
+	report := set.Report()
+	assert.Equal(t, 3, report.Found)
+	assert.Equal(t, 1, report.Duplicate)
+}
+
+// TestFetchReport tests that Fetch reports rejected rows and HTTP errors recorded by a provider.
+func TestFetchReport(t *testing.T) {
+	provider := func(proxies *providers.Set, req providers.Request) ([]providers.Proxy, error) {
+		proxies.Add(mustProxy(t, "http://1.1.1.1:80"))
+		proxies.Add(mustProxy(t, "http://1.1.1.1:80"))
+
+		proxies.Reject(providers.RejectBadCountry)
+		proxies.Reject(providers.RejectBadCountry)
+		proxies.Reject(providers.RejectMalformedIP)
+		proxies.HTTPError()
+
+		return proxies.List(), nil
+	}
+
+	ps, report, err := providers.Fetch("Test", provider, time.Second)
+	assert.Nil(t, err)
+	assert.Len(t, ps, 1)
+
+	assert.Equal(t, "Test", report.Provider)
+	assert.Equal(t, 2, report.Found)
+	assert.Equal(t, 1, report.Unique)
+	assert.Equal(t, 1, report.Duplicate)
+	assert.Equal(t, 2, report.Rejected[providers.RejectBadCountry])
+	assert.Equal(t, 3, report.TotalRejected())
+	assert.Equal(t, 1, report.HTTPErrors)
+	assert.Empty(t, report.Error)
+
+	_, report, err = providers.Fetch("DummyProviderError", providers.DummyProviderError, time.Second)
+	assert.NotNil(t, err)
+	assert.Equal(t, err.Error(), report.Error)
+}
@@ -0,0 +1,61 @@
+package providers
+
+import (
+	"context"
+	"net/url"
+	"time"
+)
+
+// Request is what a provider is asked for each time it is run.
+type Request struct {
+	// Timeout is how long the provider has to find proxies.
+	Timeout time.Duration
+
+	// Hints describe the proxies wanted, for providers which can filter on the server side.
+	Hints Hints
+
+	// Parent is the parent proxy the requests the provider makes go through. If it is nil, the one chosen by
+	// SetParentProxy is used.
+	Parent *url.URL
+
+	// Prober detects the scheme of proxies found without one. If it is nil, DefaultProber is used.
+	Prober *Prober
+}
+
+// NewRequest creates a request with the timeout given and no hints or parent proxy.
+func NewRequest(timeout time.Duration) Request {
+	return Request{Timeout: timeout}
+}
+
+// WithTimeout returns a copy of the request with the timeout given, for providers which run others with part of
+// their own timeout.
+func (r Request) WithTimeout(timeout time.Duration) Request {
+	r.Timeout = timeout
+	return r
+}
+
+// prober returns the prober used to detect the scheme of proxies found without one.
+func (r Request) prober() *Prober {
+	if r.Prober != nil {
+		return r.Prober
+	}
+
+	return DefaultProber
+}
+
+// context returns the context providers use for the requests they make, which carries the request's parent proxy
+// and times out after the request's timeout.
+func (r Request) context() (context.Context, context.CancelFunc) {
+	ctx := context.Background()
+	if r.Parent != nil {
+		ctx = WithParentProxy(ctx, r.Parent)
+	}
+
+	return context.WithTimeout(ctx, r.Timeout)
+}
+
+// FetchRequest is like Fetch, but runs the provider with the request given, so that it can carry hints and a
+// parent proxy.
+func FetchRequest(name string, provider Provider, req Request) ([]Proxy, Report, error) {
+	return fetch(name, provider, req, NewSet())
+}
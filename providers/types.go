@@ -4,13 +4,11 @@ import (
 	"fmt"
 	"net/url"
 	"sync"
-	"time"
-
-	"github.com/pkg/errors"
 )
 
-// Provider is a type alias representing a proxy provider.
-type Provider func(*Set, time.Duration) ([]Proxy, error)
+// Provider is a type alias representing a proxy provider. It adds the proxies it finds to the set it is given,
+// and returns them. The request says how long it has and which proxies are wanted.
+type Provider func(*Set, Request) ([]Proxy, error)
 
 // Proxy represents a proxy
 type Proxy struct {
@@ -18,35 +16,46 @@ type Proxy struct {
 	Provider string   `json:"providers"`
 	Country  string   `json:"country"`
 
+	// Capabilities are the capabilities the proxy has been declared or found to have, apart from those implied
+	// by its URL. See AllCapabilities.
+	Capabilities Capability `json:"capabilities"`
+
 	Used bool
 }
 
-func newProxy(rawip string, provider string, country string) (Proxy, error) {
-	u, err := url.Parse(rawip)
-	if err != nil {
-		return Proxy{}, errors.Wrap(err, "providers: cannot parse ip to URL")
+// key returns the string used to tell whether two proxies are the same. Proxies are compared by URL, as
+// two proxies parsed from the same address will never share the same *url.URL.
+func (p Proxy) key() string {
+	if p.URL == nil {
+		return ""
 	}
 
-	return Proxy{
-		URL:      u,
-		Provider: provider,
-		Country:  country,
-	}, nil
+	return p.URL.String()
 }
 
-// Set is a utility for storing the proxies in a concurrency-safe way
+// Set is a utility for storing the proxies in a concurrency-safe way. Proxies with the same URL are only
+// stored once.
 type Set struct {
 	m       sync.Mutex
 	proxies map[Proxy]bool
+	index   map[string]Proxy
+
+	added      int
+	duplicates int
+	rejected   map[RejectReason]int
+	httpErrors int
 }
 
 // Add adds a new proxy to the set.
 func (s *Set) Add(p Proxy) {
 	s.m.Lock()
 
-	exists := s.proxies[p]
-	if !exists {
+	if _, exists := s.index[p.key()]; exists {
+		s.duplicates++
+	} else {
 		s.proxies[p] = true
+		s.index[p.key()] = p
+		s.added++
 	}
 
 	s.m.Unlock()
@@ -55,7 +64,7 @@ func (s *Set) Add(p Proxy) {
 // In checks wheter a proxy is in the set.
 func (s *Set) In(p Proxy) bool {
 	s.m.Lock()
-	m := s.proxies[p]
+	_, m := s.index[p.key()]
 	s.m.Unlock()
 
 	return m
@@ -88,7 +97,11 @@ func (s *Set) All() (proxies map[Proxy]bool) {
 func (s *Set) Remove(proxy Proxy) {
 	s.m.Lock()
 
-	delete(s.proxies, proxy)
+	if stored, ok := s.index[proxy.key()]; ok {
+		delete(s.proxies, stored)
+		delete(s.index, proxy.key())
+	}
+
 	s.m.Unlock()
 }
 
@@ -120,6 +133,24 @@ func (s *Set) FromCountries(countries []string) (Proxy, error) {
 	return Proxy{}, fmt.Errorf("couldn't find proxy from country")
 }
 
+// Copy returns a new set containing the same proxies. Changes to the copy are not
+// reflected in the original set, and vice versa.
+func (s *Set) Copy() *Set {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	c := NewSet()
+	for k, v := range s.proxies {
+		c.proxies[k] = v
+	}
+
+	for k, v := range s.index {
+		c.index[k] = v
+	}
+
+	return c
+}
+
 // Length gets the amount of proxies being stores.
 func (s *Set) Length() int {
 	s.m.Lock()
@@ -132,6 +163,8 @@ func (s *Set) Length() int {
 // NewSet creates a new set.
 func NewSet() *Set {
 	return &Set{
-		proxies: make(map[Proxy]bool),
+		proxies:  make(map[Proxy]bool),
+		index:    make(map[string]Proxy),
+		rejected: make(map[RejectReason]int),
 	}
 }
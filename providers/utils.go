@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"strings"
 	"sync"
 	"time"
 
@@ -24,12 +25,14 @@ type countryDB struct {
 	initialised bool
 }
 
+// FindCountryByIP returns the ISO code of the country an IPv4 or IPv6 address is in.
 func (cdb *countryDB) FindCountryByIP(ip string) (string, error) {
 	if !cdb.initialised {
 		panic("providers: cannot access country db, exiting")
 	}
 
-	parsedIP := net.ParseIP(ip)
+	// IPv6 addresses can be given in brackets, as they are written in URLs.
+	parsedIP := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(ip, "["), "]"))
 	if parsedIP == nil {
 		return "", fmt.Errorf("providers: malformed ip address '%v'", ip)
 	}
@@ -0,0 +1,36 @@
+package prox_test
+
+import (
+	"testing"
+
+	"github.com/ollybritton/prox"
+	"github.com/ollybritton/prox/providers"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRegisterDefinitions tests that defined providers can be used by name once registered, and that nothing
+// is registered if any of the definitions are invalid.
+func TestRegisterDefinitions(t *testing.T) {
+	valid := providers.Definition{Name: "DefinedList", URLs: []string{"http://localhost/list.txt"}, Format: providers.FormatText}
+	duplicate := providers.Definition{Name: "Static", URLs: []string{"http://localhost/list.txt"}, Format: providers.FormatText}
+	invalid := providers.Definition{Name: "DefinedInvalid", Format: providers.FormatText}
+
+	assert.NotNil(t, prox.RegisterDefinitions(valid, duplicate), "a definition should not replace an existing provider")
+	assert.NotNil(t, prox.RegisterDefinitions(valid, invalid))
+	_, ok := prox.Lookup("DefinedList")
+	assert.False(t, ok, "nothing should be registered if a definition fails")
+
+	assert.Nil(t, prox.RegisterDefinitions(valid))
+	assert.Equal(t, "DefinedList", prox.GetProvider("DefinedList").Name)
+}
+
+// TestRegisterCommands tests that command providers can be used by name once registered.
+func TestRegisterCommands(t *testing.T) {
+	c := providers.Command{Name: "RegisteredCommand", Command: "prox-provider"}
+
+	assert.NotNil(t, prox.RegisterCommands(c, c), "two commands should not be able to have the same name")
+	assert.NotNil(t, prox.RegisterCommands(providers.Command{Name: "NoCommand"}))
+
+	assert.Nil(t, prox.RegisterCommands(c))
+	assert.Equal(t, "RegisteredCommand", prox.GetProvider("RegisteredCommand").Name)
+}
@@ -1,7 +1,9 @@
 package prox
 
 import (
+	"context"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -10,9 +12,6 @@ import (
 	"github.com/ollybritton/prox/providers"
 	"github.com/pkg/errors"
 	"golang.org/x/net/proxy"
-
-	// Needed to augment net/proxy to support socks4
-	_ "github.com/Bogdan-D/go-socks4"
 )
 
 // Proxy holds information about a proxy. It is like providers.Proxy,
@@ -22,22 +21,99 @@ type Proxy struct {
 	Provider string
 	Country  string
 
+	// Capabilities are the capabilities the proxy has been declared or found to have, apart from those implied
+	// by its URL. See Can.
+	Capabilities providers.Capability
+
+	// Parent is a proxy which connections to this proxy go through, such as a corporate HTTP proxy. If it is
+	// nil, the parent set with SetParentProxy is used if it applies to connections. See Client.
+	Parent *url.URL
+
+	// SSH holds the settings used to connect to the proxy if it is an ssh:// proxy. If it is nil,
+	// DefaultSSHConfig is used.
+	SSH *SSHConfig
+
+	// Tier and Weight are the priority tier and weight of the provider the proxy came from, as set by
+	// UseProviderTier. They are only set on proxies returned by a ComplexPool.
+	Tier   int
+	Weight float64
+
 	used bool
 
 	client    *http.Client
 	hasClient bool
 }
 
-// PrettyPrint prints some information in a nicely-formatted way.
+// Redacted returns the URL of the proxy as a string, with any username and password replaced so that it can
+// be printed safely.
+func (p Proxy) Redacted() string {
+	return providers.RedactURL(p.URL)
+}
+
+// String returns the URL of the proxy with any credentials replaced, so that proxies can be logged safely.
+func (p Proxy) String() string {
+	return p.Redacted()
+}
+
+// MarshalJSON encodes the proxy with its URL as a string. Any credentials in the URL are replaced, so that
+// exported proxies can be shared without leaking them.
+func (p Proxy) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		URL          string               `json:"url"`
+		Provider     string               `json:"provider"`
+		Country      string               `json:"country"`
+		Capabilities providers.Capability `json:"capabilities,omitempty"`
+		Tier         int                  `json:"tier"`
+		Weight       float64              `json:"weight"`
+	}{p.Redacted(), p.Provider, p.Country, p.Capabilities, p.Tier, p.Weight})
+}
+
+// AllCapabilities returns the capabilities the proxy is known to have, including those implied by its URL.
+func (p *Proxy) AllCapabilities() providers.Capability {
+	return p.Capabilities | providers.ImpliedCapabilities(p.URL)
+}
+
+// Can returns true if the proxy is known to have every capability given, such as
+// providers.CapabilityConnect|providers.CapabilityUDP.
+func (p *Proxy) Can(capabilities providers.Capability) bool {
+	return p.AllCapabilities().Has(capabilities)
+}
+
+// CheckCapabilities probes the proxy with providers.DefaultProber, through the proxy's parent proxy if it has one,
+// and adds any capabilities found to the proxy's. It returns every capability the proxy is known to have afterwards. Probing can only find
+// capabilities which show up in a handshake, such as whether a HTTP proxy accepts CONNECT requests or whether
+// the proxy asks for credentials. SOCKS5 proxies not already known to relay UDP are also checked with CheckUDP.
+func (p *Proxy) CheckCapabilities(timeout time.Duration) providers.Capability {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	result := parentProber(p.parentProxy()).Probe(ctx, p.URL.Host)
+	p.Capabilities |= result.Capabilities[providers.BaseScheme(p.URL.Scheme)]
+
+	if providers.BaseScheme(p.URL.Scheme) == "socks5" && !p.Can(providers.CapabilityUDP) {
+		p.CheckUDP(timeout)
+	}
+
+	return p.AllCapabilities()
+}
+
+// Family returns the address family of the proxy's host: providers.FamilyIPv4, providers.FamilyIPv6 or
+// providers.FamilyHostname.
+func (p *Proxy) Family() string {
+	return providers.AddressFamily(p.URL)
+}
+
+// PrettyPrint prints some information in a nicely-formatted way. Credentials are never printed.
 func (p *Proxy) PrettyPrint() {
-	urlString := p.URL.String()
+	urlString := p.Redacted()
 
 	fmt.Println(
 		fmt.Sprintf("(%v) %v [%v]", p.Country, urlString, p.Provider),
 	)
 }
 
-// Client gets the http.Client associated with the given proxy.
+// Client gets the http.Client associated with the given proxy. If the proxy has a parent proxy, the client
+// connects to the proxy through it, using a Chain.
 func (p *Proxy) Client() (*http.Client, error) {
 	if p.hasClient {
 		return p.client, nil
@@ -46,15 +122,29 @@ func (p *Proxy) Client() (*http.Client, error) {
 	var client *http.Client
 	var err error
 
+	if parent := p.parentProxy(); parent != nil {
+		chain, err := NewChain(Proxy{URL: parent}, *p)
+		if err != nil {
+			return &http.Client{}, err
+		}
+
+		p.client = chain.Client()
+		p.hasClient = true
+
+		return p.client, nil
+	}
+
 	switch p.URL.Scheme {
 	case "http":
 		client, err = p.AsHTTPClient()
 	case "https":
 		client, err = p.AsHTTPSClient()
-	case "socks4":
+	case "socks4", "socks4a":
 		client, err = p.AsSOCKS4Client()
-	case "socks5":
+	case "socks5", "socks5h":
 		client, err = p.AsSOCKS5Client()
+	case "ssh":
+		client, err = p.AsSSHClient()
 	default:
 		panic("prox: unknown proxy type " + p.URL.Scheme)
 	}
@@ -70,7 +160,8 @@ func (p *Proxy) Client() (*http.Client, error) {
 }
 
 // AsHTTPClient will return the proxy as a http.Client struct.
-// It panics if the proxy's type is not HTTP.
+// It panics if the proxy's type is not HTTP. If the proxy's URL has credentials, they are sent in the
+// Proxy-Authorization header of every request, including CONNECT requests for HTTPS sites.
 func (p *Proxy) AsHTTPClient() (*http.Client, error) {
 	if p.URL.Scheme != "http" {
 		panic(
@@ -85,7 +176,7 @@ func (p *Proxy) AsHTTPClient() (*http.Client, error) {
 }
 
 // AsHTTPSClient will return the proxy as a http.Client struct.
-// It panics if the proxy's type is not HTTPS.
+// It panics if the proxy's type is not HTTPS. Credentials are sent like in AsHTTPClient.
 func (p *Proxy) AsHTTPSClient() (*http.Client, error) {
 	if p.URL.Scheme != "https" {
 		panic(
@@ -103,21 +194,23 @@ func (p *Proxy) AsHTTPSClient() (*http.Client, error) {
 }
 
 // AsSOCKS4Client will return the proxy as a http.Client struct.
-// It panics if the proxy's type is not SOCKS4.
+// It panics if the proxy's type is not SOCKS4 or SOCKS4a. The username in the proxy's URL is sent as the user ID.
+// SOCKS4a proxies are sent the hostname of each request to resolve, so it is never looked up locally; SOCKS4
+// proxies can only be sent IPv4 addresses, so hostnames are resolved first.
 func (p *Proxy) AsSOCKS4Client() (*http.Client, error) {
-	if p.URL.Scheme != "socks4" {
+	if p.URL.Scheme != "socks4" && p.URL.Scheme != "socks4a" {
 		panic(
 			fmt.Errorf("prox: cannot get SOCKS4 client of %v proxy", p.URL.Scheme),
 		)
 	}
 
-	dialer, err := proxy.FromURL(p.URL, proxy.Direct)
+	dialer, err := newSOCKS4Dialer(p.URL, proxy.Direct)
 	if err != nil {
 		return &http.Client{}, fmt.Errorf("prox: cannot connect to socks4 proxy: %v", err)
 	}
 
 	transport := &http.Transport{}
-	transport.Dial = dialer.Dial
+	transport.DialContext = dialer.DialContext
 
 	client := &http.Client{Transport: transport}
 
@@ -125,21 +218,23 @@ func (p *Proxy) AsSOCKS4Client() (*http.Client, error) {
 }
 
 // AsSOCKS5Client will return the proxy as a http.Client struct.
-// It panics if the proxy's type is not SOCKS5.
+// It panics if the proxy's type is not SOCKS5 or SOCKS5h. If the proxy's URL has credentials, they are used for
+// username/password authentication. SOCKS5h proxies are sent the hostname of each request to resolve, so it is
+// never looked up locally; for SOCKS5 proxies, hostnames are resolved first, like curl does.
 func (p *Proxy) AsSOCKS5Client() (*http.Client, error) {
-	if p.URL.Scheme != "socks5" {
+	if p.URL.Scheme != "socks5" && p.URL.Scheme != "socks5h" {
 		panic(
 			fmt.Errorf("prox: cannot get SOCKS5 client of %v proxy", p.URL.Scheme),
 		)
 	}
 
-	dialer, err := proxy.FromURL(p.URL, proxy.Direct)
+	dialer, err := newSOCKS5Dialer(p.URL, proxy.Direct)
 	if err != nil {
 		return &http.Client{}, fmt.Errorf("prox: cannot connect to socks5 proxy: %v", err)
 	}
 
 	transport := &http.Transport{}
-	transport.Dial = dialer.Dial
+	transport.DialContext = dialer.DialContext
 
 	client := &http.Client{Transport: transport}
 
@@ -201,15 +296,17 @@ func (p *Proxy) CheckConnection() bool {
 // CastProxy will convert a providers.Proxy type into prox.Proxy type.
 func CastProxy(p providers.Proxy) *Proxy {
 	return &Proxy{
-		URL:      p.URL,
-		Provider: p.Provider,
-		Country:  p.Country,
+		URL:          p.URL,
+		Provider:     p.Provider,
+		Country:      p.Country,
+		Capabilities: p.Capabilities,
 	}
 }
 
-// NewProxy will create a new proxy type.
+// NewProxy will create a new proxy type. The address can be in any form accepted by providers.ParseAddress, but
+// must include the scheme.
 func NewProxy(rawip string, provider string, country string) (Proxy, error) {
-	u, err := url.Parse(rawip)
+	u, err := providers.ParseAddress(rawip, "")
 	if err != nil {
 		return Proxy{}, errors.Wrap(err, "prox: cannot parse ip to URL")
 	}
@@ -0,0 +1,444 @@
+package prox_test
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// remoteOnlyHost is a hostname which only the test proxies can resolve, to 127.0.0.1, so that requests for it
+// only work if the proxy resolves it.
+const remoteOnlyHost = "target.prox.invalid"
+
+// dialTarget connects to a target on behalf of a test proxy, resolving remoteOnlyHost.
+func dialTarget(host string, port int) (net.Conn, error) {
+	if host == remoteOnlyHost {
+		host = "127.0.0.1"
+	}
+
+	return net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+}
+
+// relay copies data between two connections until either is closed.
+func relay(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+
+	go func() {
+		io.Copy(a, b)
+		done <- struct{}{}
+	}()
+
+	go func() {
+		io.Copy(b, a)
+		done <- struct{}{}
+	}()
+
+	<-done
+	a.Close()
+	b.Close()
+}
+
+// startTarget starts a server which responds to every request with 204 No Content, to be reached through a proxy.
+func startTarget(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// startHTTPProxy starts a HTTP proxy which requires the credentials given. Plain requests are answered by the
+// proxy itself with 204 No Content, and CONNECT requests are tunnelled to their target.
+func startHTTPProxy(t *testing.T, username, password string) *httptest.Server {
+	want := "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") != want {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			target.Close()
+			return
+		}
+
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		relay(conn, target)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// startSOCKSServer starts a server listening on addr which passes each connection to handle.
+func startSOCKSServer(t *testing.T, addr string, handle func(conn net.Conn)) string {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go handle(conn)
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+// startSOCKS5Proxy starts a SOCKS5 proxy which supports CONNECT. If username is not empty, clients have to
+// authenticate with the username and password given.
+func startSOCKS5Proxy(t *testing.T, username, password string) string {
+	return startSOCKSServer(t, "127.0.0.1:0", socks5Handler(username, password, false))
+}
+
+// startSOCKS5UDPProxy starts a SOCKS5 proxy like startSOCKS5Proxy, which also supports UDP ASSOCIATE.
+func startSOCKS5UDPProxy(t *testing.T, username, password string) string {
+	return startSOCKSServer(t, "127.0.0.1:0", socks5Handler(username, password, true))
+}
+
+// socks5Handler handles a connection to a SOCKS5 proxy. See startSOCKS5Proxy and startSOCKS5UDPProxy.
+func socks5Handler(username, password string, udp bool) func(conn net.Conn) {
+	return func(conn net.Conn) {
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+
+		// Greeting: version, number of methods, methods.
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(r, header); err != nil || header[0] != 5 {
+			return
+		}
+
+		methods := make([]byte, header[1])
+		if _, err := io.ReadFull(r, methods); err != nil {
+			return
+		}
+
+		method := byte(0x00)
+		if username != "" {
+			method = 0x02
+		}
+
+		offered := false
+		for _, m := range methods {
+			offered = offered || m == method
+		}
+
+		if !offered {
+			conn.Write([]byte{5, 0xff})
+			return
+		}
+
+		conn.Write([]byte{5, method})
+
+		if method == 0x02 {
+			// Username/password subnegotiation, see RFC 1929.
+			version, _ := r.ReadByte()
+			ulen, _ := r.ReadByte()
+			user := make([]byte, ulen)
+			io.ReadFull(r, user)
+			plen, _ := r.ReadByte()
+			pass := make([]byte, plen)
+			io.ReadFull(r, pass)
+
+			if version != 1 || string(user) != username || string(pass) != password {
+				conn.Write([]byte{1, 1})
+				return
+			}
+
+			conn.Write([]byte{1, 0})
+		}
+
+		request := make([]byte, 4)
+		if _, err := io.ReadFull(r, request); err != nil {
+			return
+		}
+
+		var host string
+		switch request[3] {
+		case 1:
+			ip := make([]byte, 4)
+			io.ReadFull(r, ip)
+			host = net.IP(ip).String()
+		case 3:
+			n, _ := r.ReadByte()
+			name := make([]byte, n)
+			io.ReadFull(r, name)
+			host = string(name)
+		case 4:
+			ip := make([]byte, 16)
+			io.ReadFull(r, ip)
+			host = net.IP(ip).String()
+		}
+
+		port := make([]byte, 2)
+		io.ReadFull(r, port)
+
+		switch {
+		case request[1] == 3 && udp:
+			socks5Associate(conn)
+			return
+		case request[1] != 1:
+			conn.Write([]byte{5, 7, 0, 1, 0, 0, 0, 0, 0, 0})
+			return
+		}
+
+		target, err := dialTarget(host, int(binary.BigEndian.Uint16(port)))
+		if err != nil {
+			conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+			return
+		}
+
+		conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+		relay(&bufferedConn{conn, r}, target)
+	}
+}
+
+// socks5Associate relays UDP datagrams for the client of a SOCKS5 proxy until the connection the association was
+// made over is closed. The first datagram received is taken to be from the client; datagrams from anywhere else
+// are sent back to it.
+func socks5Associate(conn net.Conn) {
+	relay, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		conn.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer relay.Close()
+
+	reply := []byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0}
+	binary.BigEndian.PutUint16(reply[8:], uint16(relay.LocalAddr().(*net.UDPAddr).Port))
+	conn.Write(reply)
+
+	go func() {
+		var client *net.UDPAddr
+		buf := make([]byte, 65535)
+
+		for {
+			n, from, err := relay.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+
+			if client == nil {
+				client = from
+			}
+
+			if from.String() != client.String() {
+				header := []byte{0, 0, 0, 1}
+				header = append(header, from.IP.To4()...)
+				header = append(header, byte(from.Port>>8), byte(from.Port))
+				relay.WriteToUDP(append(header, buf[:n]...), client)
+
+				continue
+			}
+
+			// Datagrams from the client: reserved bytes, fragment number, then the destination.
+			if n < 4 || buf[2] != 0 {
+				continue
+			}
+
+			var host string
+			rest := buf[4:n]
+
+			switch buf[3] {
+			case 1:
+				host, rest = net.IP(rest[:4]).String(), rest[4:]
+			case 3:
+				host, rest = string(rest[1:1+rest[0]]), rest[1+rest[0]:]
+			default:
+				continue
+			}
+
+			if host == remoteOnlyHost {
+				host = "127.0.0.1"
+			}
+
+			target := &net.UDPAddr{IP: net.ParseIP(host), Port: int(binary.BigEndian.Uint16(rest[:2]))}
+			relay.WriteToUDP(rest[2:], target)
+		}
+	}()
+
+	io.Copy(ioutil.Discard, conn)
+}
+
+// startSOCKS4Proxy starts a SOCKS4 proxy which only accepts requests with the user ID given. It supports the
+// SOCKS4a extension, resolving hostnames sent after the user ID.
+func startSOCKS4Proxy(t *testing.T, userID string) string {
+	return startSOCKSServer(t, "127.0.0.1:0", func(conn net.Conn) {
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+
+		request := make([]byte, 8)
+		if _, err := io.ReadFull(r, request); err != nil || request[0] != 4 || request[1] != 1 {
+			return
+		}
+
+		user, err := r.ReadString(0)
+		if err != nil {
+			return
+		}
+
+		if user[:len(user)-1] != userID {
+			conn.Write([]byte{0, 0x5d, 0, 0, 0, 0, 0, 0})
+			return
+		}
+
+		port := binary.BigEndian.Uint16(request[2:4])
+		host := net.IP(request[4:8]).String()
+
+		// SOCKS4a: an address of 0.0.0.x is followed by the hostname to connect to.
+		if request[4] == 0 && request[5] == 0 && request[6] == 0 && request[7] != 0 {
+			name, err := r.ReadString(0)
+			if err != nil {
+				return
+			}
+
+			host = name[:len(name)-1]
+		}
+
+		target, err := dialTarget(host, int(port))
+		if err != nil {
+			conn.Write([]byte{0, 0x5b, 0, 0, 0, 0, 0, 0})
+			return
+		}
+
+		conn.Write([]byte{0, 0x5a, 0, 0, 0, 0, 0, 0})
+		relay(&bufferedConn{conn, r}, target)
+	})
+}
+
+// startSSHProxy starts an SSH server which accepts the password given, unless it is empty, or the public key
+// given, unless it is nil, and forwards direct-tcpip channels to their target. It returns the address of the server
+// and its host key.
+func startSSHProxy(t *testing.T, username, password string, authorized ssh.PublicKey) (string, ssh.PublicKey) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hostKey, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if authorized != nil && c.User() == username && bytes.Equal(key.Marshal(), authorized.Marshal()) {
+				return nil, nil
+			}
+
+			return nil, fmt.Errorf("unknown key")
+		},
+	}
+
+	if password != "" {
+		config.PasswordCallback = func(c ssh.ConnMetadata, given []byte) (*ssh.Permissions, error) {
+			if c.User() == username && string(given) == password {
+				return nil, nil
+			}
+
+			return nil, fmt.Errorf("invalid credentials")
+		}
+	}
+
+	config.AddHostKey(hostKey)
+
+	addr := startSOCKSServer(t, "127.0.0.1:0", func(conn net.Conn) {
+		_, chans, reqs, err := ssh.NewServerConn(conn, config)
+		if err != nil {
+			conn.Close()
+			return
+		}
+
+		go ssh.DiscardRequests(reqs)
+
+		for newChannel := range chans {
+			if newChannel.ChannelType() != "direct-tcpip" {
+				newChannel.Reject(ssh.UnknownChannelType, "only direct-tcpip channels are supported")
+				continue
+			}
+
+			var request struct {
+				Host       string
+				Port       uint32
+				OriginHost string
+				OriginPort uint32
+			}
+
+			if err := ssh.Unmarshal(newChannel.ExtraData(), &request); err != nil {
+				newChannel.Reject(ssh.ConnectionFailed, "invalid request")
+				continue
+			}
+
+			target, err := dialTarget(request.Host, int(request.Port))
+			if err != nil {
+				newChannel.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+
+			channel, channelReqs, err := newChannel.Accept()
+			if err != nil {
+				target.Close()
+				continue
+			}
+
+			go ssh.DiscardRequests(channelReqs)
+
+			go func() {
+				io.Copy(channel, target)
+				channel.Close()
+			}()
+
+			go func() {
+				io.Copy(target, channel)
+				target.Close()
+			}()
+		}
+	})
+
+	return addr, hostKey.PublicKey()
+}
+
+// bufferedConn is a connection whose reads go through a buffered reader, so that nothing it has already
+// buffered is lost.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
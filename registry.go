@@ -0,0 +1,174 @@
+package prox
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ProviderInfo describes a provider in a registry.
+type ProviderInfo struct {
+	Description string
+
+	// Schemes are the schemes of the proxies the provider finds, such as "http" or "socks5". It is empty if
+	// they aren't known in advance.
+	Schemes []string
+
+	// NeedsNetwork is true if the provider has to make requests to find proxies.
+	NeedsNetwork bool
+
+	// Default is true if the provider should be used when none are given, for example by the CLI.
+	Default bool
+}
+
+// Registry is a concurrency-safe collection of providers which can be looked up by name.
+type Registry struct {
+	mu        sync.RWMutex
+	providers map[string]Provider
+	info      map[string]ProviderInfo
+}
+
+// NewRegistry creates a new, empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		providers: make(map[string]Provider),
+		info:      make(map[string]ProviderInfo),
+	}
+}
+
+// Register adds a provider to the registry. It returns an error if a provider with the same name has already
+// been registered.
+func (r *Registry) Register(provider Provider, info ProviderInfo) error {
+	return r.registerAll([]Provider{provider}, []ProviderInfo{info})
+}
+
+// MustRegister is like Register, but panics if the provider can't be registered.
+func (r *Registry) MustRegister(provider Provider, info ProviderInfo) {
+	if err := r.Register(provider, info); err != nil {
+		panic(err)
+	}
+}
+
+// registerAll adds several providers to the registry at once. Nothing is registered if any of them can't be.
+func (r *Registry) registerAll(givenProviders []Provider, infos []ProviderInfo) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := map[string]bool{}
+
+	for _, provider := range givenProviders {
+		if provider.Name == "" || provider.InternalProvider == nil {
+			return fmt.Errorf("prox: cannot register provider %q, it needs a name and a function", provider.Name)
+		}
+
+		if _, ok := r.providers[provider.Name]; ok || names[provider.Name] {
+			return fmt.Errorf("prox: a provider named %v is already registered", provider.Name)
+		}
+
+		names[provider.Name] = true
+	}
+
+	for i, provider := range givenProviders {
+		r.providers[provider.Name] = provider
+		r.info[provider.Name] = infos[i]
+	}
+
+	return nil
+}
+
+// Lookup returns the provider with the name given, and whether it exists.
+func (r *Registry) Lookup(name string) (Provider, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	provider, ok := r.providers[name]
+	return provider, ok
+}
+
+// Info returns the information about the provider with the name given, and whether it exists.
+func (r *Registry) Info(name string) (ProviderInfo, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	info, ok := r.info[name]
+	return info, ok
+}
+
+// List returns the names of every provider in the registry, sorted.
+func (r *Registry) List() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.providers))
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Defaults returns the names of the providers which should be used when none are given, sorted.
+func (r *Registry) Defaults() []string {
+	names := []string{}
+
+	for _, name := range r.List() {
+		if info, _ := r.Info(name); info.Default {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// DefaultRegistry is the registry used to look up providers by name, such as by GetProvider and the CLI. It
+// starts with the built-in providers.
+var DefaultRegistry = newDefaultRegistry()
+
+// builtinInfo holds the information about the built-in providers in DefaultRegistry.
+var builtinInfo = map[string]ProviderInfo{
+	"FreeProxyLists": {
+		Description:  "Scrapes the proxy lists on freeproxylists.com",
+		Schemes:      []string{"http"},
+		NeedsNetwork: true,
+		Default:      true,
+	},
+	"ProxyScrape": {
+		Description:  "Fetches proxies from the proxyscrape.com API",
+		Schemes:      []string{"http", "socks4", "socks5"},
+		NeedsNetwork: true,
+		Default:      true,
+	},
+	"GetProxyList": {
+		Description:  "Fetches proxies one at a time from the getproxylist.com API",
+		Schemes:      []string{"http", "socks4", "socks5"},
+		NeedsNetwork: true,
+		Default:      true,
+	},
+	"Static": {
+		Description: "A list of proxies built into prox, which can be used offline",
+		Schemes:     []string{"http", "https", "socks4", "socks5"},
+	},
+}
+
+// newDefaultRegistry creates a registry holding the providers in the deprecated Providers map, which starts with
+// the built-in providers.
+func newDefaultRegistry() *Registry {
+	r := NewRegistry()
+
+	for name, provider := range Providers {
+		r.MustRegister(provider, builtinInfo[name])
+	}
+
+	return r
+}
+
+// Register adds a provider to the default registry, so that it can be used by name.
+func Register(provider Provider, info ProviderInfo) error {
+	return DefaultRegistry.Register(provider, info)
+}
+
+// Lookup returns the provider with the name given from the default registry, and whether it exists.
+func Lookup(name string) (Provider, bool) {
+	return DefaultRegistry.Lookup(name)
+}
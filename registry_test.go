@@ -0,0 +1,80 @@
+package prox_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/ollybritton/prox"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRegistry tests registering and looking up providers.
+func TestRegistry(t *testing.T) {
+	registry := prox.NewRegistry()
+
+	assert.Nil(t, registry.Register(DummyProvider, prox.ProviderInfo{Default: true, Schemes: []string{"http"}}))
+	assert.Nil(t, registry.Register(DummyProviderEmpty, prox.ProviderInfo{}))
+
+	assert.NotNil(t, registry.Register(DummyProvider, prox.ProviderInfo{}), "a name should only be registered once")
+	assert.NotNil(t, registry.Register(prox.Provider{Name: "NoFunction"}, prox.ProviderInfo{}))
+
+	provider, ok := registry.Lookup("DummyProvider")
+	assert.True(t, ok)
+	assert.Equal(t, "DummyProvider", provider.Name)
+
+	_, ok = registry.Lookup("Missing")
+	assert.False(t, ok)
+
+	info, ok := registry.Info("DummyProvider")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"http"}, info.Schemes)
+
+	assert.Equal(t, []string{"DummyProvider", "DummyProviderEmpty"}, registry.List())
+	assert.Equal(t, []string{"DummyProvider"}, registry.Defaults())
+}
+
+// TestRegistryConcurrent tests that providers can be registered and looked up at the same time.
+func TestRegistryConcurrent(t *testing.T) {
+	registry := prox.NewRegistry()
+	wg := &sync.WaitGroup{}
+
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+
+		go func(i int) {
+			defer wg.Done()
+
+			provider := prox.Provider{Name: fmt.Sprintf("Provider%d", i), InternalProvider: DummyProvider.InternalProvider}
+			assert.Nil(t, registry.Register(provider, prox.ProviderInfo{}))
+		}(i)
+
+		go func() {
+			defer wg.Done()
+
+			registry.Lookup("Provider0")
+			registry.List()
+		}()
+	}
+
+	wg.Wait()
+	assert.Len(t, registry.List(), 50)
+}
+
+// TestDefaultRegistry tests that the built-in providers are registered, and that Static isn't used by default.
+func TestDefaultRegistry(t *testing.T) {
+	for _, name := range []string{"FreeProxyLists", "ProxyScrape", "GetProxyList", "Static"} {
+		_, ok := prox.Lookup(name)
+		assert.True(t, ok, name)
+	}
+
+	assert.Equal(t, []string{"FreeProxyLists", "GetProxyList", "ProxyScrape"}, prox.DefaultRegistry.Defaults())
+
+	info, _ := prox.DefaultRegistry.Info("Static")
+	assert.False(t, info.NeedsNetwork)
+
+	for name := range prox.Providers {
+		_, ok := prox.Lookup(name)
+		assert.True(t, ok, "the deprecated Providers map should seed the default registry")
+	}
+}
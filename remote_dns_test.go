@@ -0,0 +1,105 @@
+package prox_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/ollybritton/prox"
+	"github.com/ollybritton/prox/providers"
+	"github.com/stretchr/testify/assert"
+)
+
+// remoteOnlyURL returns the URL of the target given with its host replaced by remoteOnlyHost.
+func remoteOnlyURL(t *testing.T, target string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u.Host = remoteOnlyHost + ":" + u.Port()
+	return u.String()
+}
+
+// TestSOCKS5hRemoteDNS tests that socks5h proxies are sent hostnames to resolve themselves, while socks5 proxies
+// resolve them locally.
+func TestSOCKS5hRemoteDNS(t *testing.T) {
+	target := startTarget(t)
+	addr := startSOCKS5Proxy(t, "user", "pass")
+
+	p := proxyFor(t, "socks5h://user:pass@"+addr)
+	assert.True(t, p.Can(providers.CapabilityRemoteDNS))
+
+	status, err := get(t, p, remoteOnlyURL(t, target.URL))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNoContent, status)
+
+	p = proxyFor(t, "socks5://user:pass@"+addr)
+	assert.False(t, p.Can(providers.CapabilityRemoteDNS))
+
+	status, err = get(t, p, target.URL)
+	assert.Nil(t, err, "IP addresses should still be sent as they are")
+	assert.Equal(t, http.StatusNoContent, status)
+
+	_, err = get(t, p, remoteOnlyURL(t, target.URL))
+	if assert.NotNil(t, err, "socks5 proxies should resolve hostnames locally") {
+		assert.Contains(t, err.Error(), "cannot find an address")
+	}
+}
+
+// TestSOCKS4aRemoteDNS tests that socks4a proxies are sent hostnames to resolve themselves, while socks4 proxies
+// need them to be resolved locally.
+func TestSOCKS4aRemoteDNS(t *testing.T) {
+	target := startTarget(t)
+	addr := startSOCKS4Proxy(t, "alice")
+
+	status, err := get(t, proxyFor(t, "socks4a://alice@"+addr), remoteOnlyURL(t, target.URL))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNoContent, status)
+
+	status, err = get(t, proxyFor(t, "socks4a://alice@"+addr), target.URL)
+	assert.Nil(t, err, "IP addresses should still be sent as they are")
+	assert.Equal(t, http.StatusNoContent, status)
+
+	_, err = get(t, proxyFor(t, "socks4://alice@"+addr), remoteOnlyURL(t, target.URL))
+	if assert.NotNil(t, err, "socks4 proxies should resolve hostnames locally") {
+		assert.Contains(t, err.Error(), "cannot find an IPv4 address")
+	}
+}
+
+// TestFilterProxyTypesRemoteDNS tests that the remote DNS schemes can be filtered by, and are matched exactly.
+func TestFilterProxyTypesRemoteDNS(t *testing.T) {
+	filter := prox.FilterProxyTypes("SOCKS5H", "socks4a")
+
+	for rawurl, want := range map[string]bool{
+		"socks5h://1.1.1.1:1080": true,
+		"socks4a://1.1.1.1:1080": true,
+		"socks5://1.1.1.1:1080":  false,
+		"http://1.1.1.1:80":      false,
+	} {
+		p := proxyFor(t, rawurl)
+		assert.Equal(t, want, filter(&p), rawurl)
+	}
+
+	assert.Panics(t, func() { prox.FilterProxyTypes("socks6") })
+}
+
+// TestWithRemoteDNS tests that SOCKS proxies are given the remote DNS schemes, and other proxies are left alone.
+func TestWithRemoteDNS(t *testing.T) {
+	provider := prox.WithRemoteDNS(sequenceProvider("Sequence", []string{
+		"http://1.1.1.1:80",
+		"socks4://2.2.2.2:1080",
+		"socks5://3.3.3.3:1080",
+		"socks5h://4.4.4.4:1080",
+	}))
+	assert.Equal(t, "RemoteDNS{Sequence}", provider.Name)
+
+	ps, _, err := fetchProvider(provider)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"http://1.1.1.1:80",
+		"socks4a://2.2.2.2:1080",
+		"socks5h://3.3.3.3:1080",
+		"socks5h://4.4.4.4:1080",
+	}, sortedURLs(ps))
+}
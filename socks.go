@@ -0,0 +1,213 @@
+package prox
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+const (
+	socks4Version        = 0x04
+	socks4CommandConnect = 0x01
+
+	// The replies a SOCKS4 server can give to a request.
+	socks4Granted        = 0x5a
+	socks4Rejected       = 0x5b
+	socks4IdentdMissing  = 0x5c
+	socks4IdentdMismatch = 0x5d
+
+	socks4ReplyLength     = 8
+	socks4MaxUserIDLength = 255
+)
+
+// socks4Dialer makes connections through a SOCKS4 proxy. The username in the proxy's URL, if it has one, is sent
+// as the user ID.
+//
+// If the proxy's scheme is socks4a, hostnames are sent to the proxy to resolve using the SOCKS4a extension, and
+// never looked up locally. Otherwise they are resolved to an IPv4 address before connecting.
+type socks4Dialer struct {
+	proxy   *url.URL
+	forward proxy.Dialer
+}
+
+// newSOCKS4Dialer creates a dialer which connects to the SOCKS4 proxy at u using forward.
+func newSOCKS4Dialer(u *url.URL, forward proxy.Dialer) (*socks4Dialer, error) {
+	if u.User != nil && len(u.User.Username()) > socks4MaxUserIDLength {
+		return nil, fmt.Errorf("prox: socks4 user id is longer than %d bytes", socks4MaxUserIDLength)
+	}
+
+	return &socks4Dialer{proxy: u, forward: forward}, nil
+}
+
+// Dial connects to addr through the proxy.
+func (d *socks4Dialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext connects to addr through the proxy. The context only applies to connecting and the handshake.
+func (d *socks4Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4":
+	default:
+		return nil, fmt.Errorf("prox: socks4 proxies cannot make %v connections", network)
+	}
+
+	host, rawport, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("prox: invalid socks4 target %v: %v", addr, err)
+	}
+
+	port, err := strconv.ParseUint(rawport, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("prox: invalid socks4 target port %v", rawport)
+	}
+
+	ip := net.ParseIP(host)
+	if ip != nil && ip.To4() == nil {
+		return nil, fmt.Errorf("prox: socks4 proxies cannot connect to IPv6 address %v", host)
+	}
+
+	// SOCKS4a asks the proxy to resolve the hostname sent after the user ID, by giving the invalid IP address
+	// 0.0.0.x instead of the target's.
+	remoteHost := ""
+
+	switch {
+	case ip != nil:
+	case d.proxy.Scheme == "socks4a":
+		if len(host) > socks4MaxUserIDLength {
+			return nil, fmt.Errorf("prox: socks4a target hostname is longer than %d bytes", socks4MaxUserIDLength)
+		}
+
+		ip = net.IPv4(0, 0, 0, 1)
+		remoteHost = host
+	default:
+		ips, err := net.DefaultResolver.LookupIP(ctx, "ip4", host)
+		if err != nil || len(ips) == 0 {
+			return nil, fmt.Errorf("prox: cannot find an IPv4 address for socks4 target %v: %v", host, err)
+		}
+
+		ip = ips[0]
+	}
+
+	conn, err := dialContext(ctx, d.forward, "tcp", d.proxy.Host)
+	if err != nil {
+		return nil, fmt.Errorf("prox: cannot connect to socks4 proxy: %v", err)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	userID := ""
+	if d.proxy.User != nil {
+		userID = d.proxy.User.Username()
+	}
+
+	request := []byte{socks4Version, socks4CommandConnect, 0, 0}
+	binary.BigEndian.PutUint16(request[2:], uint16(port))
+	request = append(request, ip.To4()...)
+	request = append(request, userID...)
+	request = append(request, 0)
+
+	if remoteHost != "" {
+		request = append(request, remoteHost...)
+		request = append(request, 0)
+	}
+
+	if _, err := conn.Write(request); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("prox: cannot send socks4 request: %v", err)
+	}
+
+	reply := make([]byte, socks4ReplyLength)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("prox: cannot read socks4 reply: %v", err)
+	}
+
+	switch reply[1] {
+	case socks4Granted:
+		return conn, nil
+	case socks4IdentdMissing, socks4IdentdMismatch:
+		conn.Close()
+		return nil, fmt.Errorf("prox: socks4 proxy rejected the user id (code %#x)", reply[1])
+	default:
+		conn.Close()
+		return nil, fmt.Errorf("prox: socks4 proxy rejected the connection (code %#x)", reply[1])
+	}
+}
+
+// socks5Dialer makes connections through a SOCKS5 proxy. If the proxy's scheme is socks5h, hostnames are sent to
+// the proxy to resolve, and never looked up locally. Otherwise they are resolved before connecting, as socks4
+// proxies and curl do.
+type socks5Dialer struct {
+	proxy  *url.URL
+	dialer proxy.Dialer
+}
+
+// newSOCKS5Dialer creates a dialer which connects to the SOCKS5 proxy at u using forward. Any credentials in u are
+// used for username/password authentication.
+func newSOCKS5Dialer(u *url.URL, forward proxy.Dialer) (*socks5Dialer, error) {
+	dialer, err := proxy.FromURL(u, forward)
+	if err != nil {
+		return nil, err
+	}
+
+	return &socks5Dialer{proxy: u, dialer: dialer}, nil
+}
+
+// Dial connects to addr through the proxy.
+func (d *socks5Dialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext connects to addr through the proxy.
+func (d *socks5Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d.proxy.Scheme != "socks5h" {
+		var err error
+
+		addr, err = resolveTarget(ctx, addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return dialContext(ctx, d.dialer, network, addr)
+}
+
+// resolveTarget replaces the hostname in addr, in the form host:port, with its IP address. Addresses with an IP
+// address are returned as they are.
+func resolveTarget(ctx context.Context, addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("prox: invalid target %v: %v", addr, err)
+	}
+
+	if net.ParseIP(host) != nil {
+		return addr, nil
+	}
+
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+	if err != nil || len(ips) == 0 {
+		return "", fmt.Errorf("prox: cannot find an address for target %v: %v", host, err)
+	}
+
+	return net.JoinHostPort(ips[0].String(), port), nil
+}
+
+// dialContext dials using the dialer given, honouring the context if the dialer supports it.
+func dialContext(ctx context.Context, d proxy.Dialer, network, addr string) (net.Conn, error) {
+	if cd, ok := d.(proxy.ContextDialer); ok {
+		return cd.DialContext(ctx, network, addr)
+	}
+
+	return d.Dial(network, addr)
+}
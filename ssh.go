@@ -0,0 +1,325 @@
+package prox
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ollybritton/prox/providers"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SSHConfig holds the settings used to connect to ssh:// proxies. SSH proxies are SSH servers, such as bastion
+// hosts, which connections are tunnelled through using port forwarding, like ssh -W does.
+type SSHConfig struct {
+	// ConfigFile is an ssh_config file which is read for the IdentityFile and UserKnownHostsFile options of each
+	// host. If it is empty or missing, only the settings here are used.
+	ConfigFile string
+
+	// IdentityFiles are the private keys tried for public key authentication, after any given for the host in
+	// ConfigFile. Keys which don't exist or are protected by a passphrase are skipped.
+	IdentityFiles []string
+
+	// Signers are extra keys tried for public key authentication, after those in IdentityFiles.
+	Signers []ssh.Signer
+
+	// KnownHostsFiles are the known_hosts files the host keys of servers are checked against, unless ConfigFile
+	// gives others for the host. Files which don't exist are skipped.
+	KnownHostsFiles []string
+
+	// HostKeyCallback checks the host keys of servers instead of KnownHostsFiles if it is set.
+	HostKeyCallback ssh.HostKeyCallback
+
+	// Timeout is the longest connecting to a server and the handshake can take. If it is zero, there is no limit
+	// apart from any deadline of the context used to connect.
+	Timeout time.Duration
+}
+
+// NewSSHConfig creates an SSHConfig which uses the same files as ssh does by default: ~/.ssh/config,
+// the id_ed25519, id_ecdsa and id_rsa keys in ~/.ssh, and ~/.ssh/known_hosts.
+func NewSSHConfig() *SSHConfig {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return &SSHConfig{Timeout: 30 * time.Second}
+	}
+
+	dir := filepath.Join(home, ".ssh")
+
+	return &SSHConfig{
+		ConfigFile: filepath.Join(dir, "config"),
+		IdentityFiles: []string{
+			filepath.Join(dir, "id_ed25519"),
+			filepath.Join(dir, "id_ecdsa"),
+			filepath.Join(dir, "id_rsa"),
+		},
+		KnownHostsFiles: []string{filepath.Join(dir, "known_hosts")},
+		Timeout:         30 * time.Second,
+	}
+}
+
+// DefaultSSHConfig is used to connect to ssh:// proxies which don't have an SSHConfig of their own.
+var DefaultSSHConfig = NewSSHConfig()
+
+// sshConfig returns the settings used to connect to the proxy if it is an SSH server.
+func (p *Proxy) sshConfig() *SSHConfig {
+	if p.SSH != nil {
+		return p.SSH
+	}
+
+	return DefaultSSHConfig
+}
+
+// clientConfig creates the configuration used to connect to the SSH server at u. The username in u is used to
+// log in, and the password, if there is one, is tried after public key authentication. Host keys are always
+// checked: if there is no HostKeyCallback and none of the known_hosts files exist, it returns an error rather than
+// trusting any key.
+func (c *SSHConfig) clientConfig(u *url.URL) (*ssh.ClientConfig, error) {
+	identityFiles, knownHostsFiles := c.IdentityFiles, c.KnownHostsFiles
+
+	if c.ConfigFile != "" {
+		if f, err := os.Open(c.ConfigFile); err == nil {
+			hosts, err := providers.ParseSSHConfig(f)
+			f.Close()
+
+			if err != nil {
+				return nil, err
+			}
+
+			host := providers.LookupSSHHost(hosts, u.Hostname())
+			identityFiles = append(host.IdentityFiles, identityFiles...)
+			if len(host.KnownHostsFiles) != 0 {
+				knownHostsFiles = host.KnownHostsFiles
+			}
+		}
+	}
+
+	callback := c.HostKeyCallback
+	if callback == nil {
+		existing := []string{}
+		for _, path := range knownHostsFiles {
+			if _, err := os.Stat(path); err == nil {
+				existing = append(existing, path)
+			}
+		}
+
+		if len(existing) == 0 {
+			return nil, fmt.Errorf("prox: no known_hosts file to check the host key of %v against", u.Host)
+		}
+
+		var err error
+
+		callback, err = knownhosts.New(existing...)
+		if err != nil {
+			return nil, fmt.Errorf("prox: cannot read known_hosts: %v", err)
+		}
+	}
+
+	signers := []ssh.Signer{}
+	for _, path := range identityFiles {
+		signer, err := loadSSHKey(path)
+		if err != nil {
+			logger.Debugf("prox (%v): skipping ssh key %v: %v", u.Host, path, err)
+			continue
+		}
+
+		signers = append(signers, signer)
+	}
+
+	signers = append(signers, c.Signers...)
+
+	auth := []ssh.AuthMethod{}
+	if len(signers) != 0 {
+		auth = append(auth, ssh.PublicKeys(signers...))
+	}
+
+	if password, ok := u.User.Password(); ok {
+		auth = append(auth, ssh.Password(password))
+	}
+
+	return &ssh.ClientConfig{
+		User:            u.User.Username(),
+		Auth:            auth,
+		HostKeyCallback: callback,
+	}, nil
+}
+
+// loadSSHKey reads an unencrypted private key from the file at path.
+func loadSSHKey(path string) (ssh.Signer, error) {
+	key, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ssh.ParsePrivateKey(key)
+}
+
+// sshHandshake logs in to the SSH server at u, which conn is connected to, and returns the client. conn is closed
+// if it fails.
+func sshHandshake(ctx context.Context, conn net.Conn, u *url.URL, config *SSHConfig) (*ssh.Client, error) {
+	if u.User == nil {
+		conn.Close()
+		return nil, fmt.Errorf("no username given for ssh server")
+	}
+
+	clientConfig, err := config.clientConfig(u)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	// The host key is checked against the host in the URL rather than the address conn is connected to, which
+	// is another proxy's if the server is part of a chain.
+	c, chans, reqs, err := ssh.NewClientConn(conn, u.Host, clientConfig)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("ssh handshake failed: %v", err)
+	}
+
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// sshDialer makes connections through an SSH server. It stays logged in between connections, and logs in again
+// once the connection to the server is lost.
+type sshDialer struct {
+	proxy  *url.URL
+	config *SSHConfig
+
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+// Dial connects to addr through the server.
+func (d *sshDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext connects to addr through the server. Hostnames are resolved by the server.
+func (d *sshDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("prox: ssh proxies cannot make %v connections", network)
+	}
+
+	client, err := d.connect(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("prox: cannot connect to ssh proxy: %v", err)
+	}
+
+	return client.DialContext(ctx, network, addr)
+}
+
+// connect returns the client logged in to the server, logging in if it isn't already.
+func (d *sshDialer) connect(ctx context.Context) (*ssh.Client, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.client != nil {
+		return d.client, nil
+	}
+
+	if d.config.Timeout != 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, d.config.Timeout)
+		defer cancel()
+	}
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", d.proxy.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := sshHandshake(ctx, conn, d.proxy, d.config)
+	if err != nil {
+		return nil, err
+	}
+
+	d.client = client
+
+	go func() {
+		client.Wait()
+
+		d.mu.Lock()
+		if d.client == client {
+			d.client = nil
+		}
+		d.mu.Unlock()
+	}()
+
+	return client, nil
+}
+
+// AsSSHClient will return the proxy as a http.Client struct.
+// It panics if the proxy's type is not SSH. Requests are tunnelled through the server with port forwarding, over
+// a single SSH connection which is kept open. See SSHConfig for how the server is logged in to.
+func (p *Proxy) AsSSHClient() (*http.Client, error) {
+	if p.URL.Scheme != "ssh" {
+		panic(
+			fmt.Errorf("prox: cannot get SSH client of %v proxy", p.URL.Scheme),
+		)
+	}
+
+	if p.URL.User == nil {
+		return &http.Client{}, fmt.Errorf("prox: cannot connect to ssh proxy: no username given")
+	}
+
+	dialer := &sshDialer{proxy: p.URL, config: p.sshConfig()}
+
+	transport := &http.Transport{}
+	transport.DialContext = dialer.DialContext
+
+	client := &http.Client{Transport: transport}
+
+	return client, nil
+}
+
+// sshTunnel logs in to the SSH server at u, which conn is connected to, and connects to addr through it. The
+// connection returned logs out of the server when it is closed.
+func sshTunnel(ctx context.Context, conn net.Conn, u *url.URL, config *SSHConfig, addr string) (net.Conn, error) {
+	if config.Timeout != 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+
+	client, err := sshHandshake(ctx, conn, u, config)
+	if err != nil {
+		return nil, err
+	}
+
+	tunnelled, err := client.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return &sshConn{tunnelled, client}, nil
+}
+
+// sshConn is a connection tunnelled through an SSH server which logs out of the server when it is closed.
+type sshConn struct {
+	net.Conn
+	client *ssh.Client
+}
+
+func (c *sshConn) Close() error {
+	err := c.Conn.Close()
+	c.client.Close()
+
+	return err
+}
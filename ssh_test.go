@@ -0,0 +1,203 @@
+package prox_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ollybritton/prox"
+	"github.com/ollybritton/prox/providers"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshDir creates a temporary directory for keys and config files, removed when the test ends.
+func sshDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "prox-ssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return dir
+}
+
+// knownHostsFor writes a known_hosts file in dir saying the server at addr has the host key given, and returns
+// its path.
+func knownHostsFor(t *testing.T, dir, addr string, key ssh.PublicKey) string {
+	path := filepath.Join(dir, "known_hosts")
+	if err := ioutil.WriteFile(path, []byte(knownhosts.Line([]string{addr}, key)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// clientKey generates a key to log in to a test server with, and writes it to a file in dir.
+func clientKey(t *testing.T, dir string) (ssh.Signer, string) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "id_ed25519")
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return signer, path
+}
+
+// TestSSHProxy tests that requests go through SSH servers using password and public key authentication, and
+// that the servers resolve hostnames.
+func TestSSHProxy(t *testing.T) {
+	target := startTarget(t)
+	dir := sshDir(t)
+
+	addr, hostKey := startSSHProxy(t, "user", "pass", nil)
+	config := &prox.SSHConfig{KnownHostsFiles: []string{knownHostsFor(t, dir, addr, hostKey)}}
+
+	p := proxyFor(t, "ssh://user:pass@"+addr)
+	p.SSH = config
+	assert.True(t, p.Can(providers.CapabilityConnect|providers.CapabilityRemoteDNS|providers.CapabilityAuth))
+
+	for _, u := range []string{target.URL, remoteOnlyURL(t, target.URL)} {
+		status, err := get(t, p, u)
+		assert.Nil(t, err, u)
+		assert.Equal(t, http.StatusNoContent, status, u)
+	}
+
+	signer, keyPath := clientKey(t, dir)
+	addr, hostKey = startSSHProxy(t, "deploy", "", signer.PublicKey())
+	knownHosts := knownHostsFor(t, dir, addr, hostKey)
+
+	p = proxyFor(t, "ssh://deploy@"+addr)
+	p.SSH = &prox.SSHConfig{IdentityFiles: []string{keyPath}, KnownHostsFiles: []string{knownHosts}}
+
+	status, err := get(t, p, target.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNoContent, status)
+
+	// Identity files can also be given for each host in an ssh_config file.
+	configFile := filepath.Join(dir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("Host 127.0.0.1\n  IdentityFile "+keyPath+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	p = proxyFor(t, "ssh://deploy@"+addr)
+	p.SSH = &prox.SSHConfig{ConfigFile: configFile, KnownHostsFiles: []string{knownHosts}}
+
+	status, err = get(t, p, target.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNoContent, status)
+}
+
+// TestSSHProxyErrors tests that SSH servers whose host key can't be verified aren't trusted, and that failing to
+// log in returns an error.
+func TestSSHProxyErrors(t *testing.T) {
+	target := startTarget(t)
+	dir := sshDir(t)
+
+	addr, hostKey := startSSHProxy(t, "user", "s3cret", nil)
+	otherAddr, otherKey := startSSHProxy(t, "user", "s3cret", nil)
+
+	tests := []struct {
+		proxy  string
+		config *prox.SSHConfig
+		err    string
+	}{
+		{
+			"ssh://user:s3cret@" + addr,
+			&prox.SSHConfig{KnownHostsFiles: []string{knownHostsFor(t, sshDir(t), addr, otherKey)}},
+			"key mismatch",
+		},
+		{
+			"ssh://user:s3cret@" + addr,
+			&prox.SSHConfig{KnownHostsFiles: []string{knownHostsFor(t, sshDir(t), otherAddr, otherKey)}},
+			"key is unknown",
+		},
+		{
+			"ssh://user:s3cret@" + addr,
+			&prox.SSHConfig{KnownHostsFiles: []string{filepath.Join(dir, "missing")}},
+			"no known_hosts file",
+		},
+		{
+			"ssh://user:wr0ng@" + addr,
+			&prox.SSHConfig{KnownHostsFiles: []string{knownHostsFor(t, sshDir(t), addr, hostKey)}},
+			"unable to authenticate",
+		},
+	}
+
+	for _, test := range tests {
+		p := proxyFor(t, test.proxy)
+		p.SSH = test.config
+
+		_, err := get(t, p, target.URL)
+		if assert.NotNil(t, err, test.err) {
+			assert.Contains(t, err.Error(), test.err)
+			assert.NotContains(t, err.Error(), "s3cret")
+		}
+	}
+}
+
+// TestChainSSH tests that SSH servers can be chained with other proxies, with their host keys checked against
+// their own address rather than the previous hop's.
+func TestChainSSH(t *testing.T) {
+	target := startTarget(t)
+	dir := sshDir(t)
+
+	addr, hostKey := startSSHProxy(t, "user", "pass", nil)
+	config := &prox.SSHConfig{KnownHostsFiles: []string{knownHostsFor(t, dir, addr, hostKey)}}
+
+	sshProxy := proxyFor(t, "ssh://user:pass@"+addr)
+	sshProxy.SSH = config
+
+	socks5 := proxyFor(t, "socks5://user:pass@"+startSOCKS5Proxy(t, "user", "pass"))
+	httpProxy := proxyFor(t, "http://user:pass@"+strings.TrimPrefix(startHTTPProxy(t, "user", "pass").URL, "http://"))
+
+	for _, proxies := range [][]prox.Proxy{{socks5, sshProxy}, {sshProxy, httpProxy}} {
+		chain, err := prox.NewChain(proxies...)
+		if !assert.Nil(t, err) {
+			continue
+		}
+
+		client := chain.Client()
+		client.Timeout = 5 * time.Second
+
+		resp, err := client.Get(target.URL)
+		if assert.Nil(t, err, chain.String()) {
+			resp.Body.Close()
+			assert.Equal(t, http.StatusNoContent, resp.StatusCode, chain.String())
+		}
+	}
+
+	sshProxy.SSH = &prox.SSHConfig{KnownHostsFiles: []string{filepath.Join(dir, "missing")}}
+
+	chain, err := prox.NewChain(socks5, sshProxy)
+	if assert.Nil(t, err) {
+		_, err = chain.Dial("tcp", strings.TrimPrefix(target.URL, "http://"))
+
+		chainErr, ok := err.(*prox.ChainError)
+		if assert.True(t, ok, "expected a *ChainError, got %v", err) {
+			assert.Equal(t, 1, chainErr.Index)
+		}
+	}
+}
@@ -0,0 +1,431 @@
+package prox
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ollybritton/prox/providers"
+)
+
+const (
+	socks5Version          = 0x05
+	socks5AuthNone         = 0x00
+	socks5AuthPassword     = 0x02
+	socks5AuthNoAcceptable = 0xff
+	socks5PasswordVersion  = 0x01
+	socks5CommandUDP       = 0x03
+	socks5Succeeded        = 0x00
+
+	// The types of address in SOCKS5 requests, replies and UDP headers.
+	socks5AddrIPv4   = 0x01
+	socks5AddrDomain = 0x03
+	socks5AddrIPv6   = 0x04
+
+	socks5MaxDatagram = 65535
+)
+
+// socks5Replies are the meanings of the error codes a SOCKS5 server can reply with.
+var socks5Replies = map[byte]string{
+	0x01: "general failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// ListenPacket asks the proxy to relay UDP datagrams using the SOCKS5 UDP ASSOCIATE command, and returns a
+// net.PacketConn whose datagrams go through the proxy. It returns an error if the proxy isn't a SOCKS5 proxy or
+// refuses the request. The context only applies to connecting and the handshake.
+//
+// The association lasts until the PacketConn is closed, or until the proxy closes the TCP connection it was made
+// over. Datagrams can be written to any net.Addr in the form host:port. As with connections, hostnames are sent
+// to socks5h proxies to resolve, and resolved locally for socks5 proxies. Fragmented datagrams from the proxy are
+// dropped.
+//
+// If the proxy has a parent proxy, the TCP connection goes through it, but datagrams are still sent to the relay
+// directly, since parent proxies can only tunnel TCP.
+func (p *Proxy) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	if p.URL.Scheme != "socks5" && p.URL.Scheme != "socks5h" {
+		return nil, fmt.Errorf("prox: cannot relay UDP through %v proxy", p.URL.Scheme)
+	}
+
+	parent := p.parentProxy()
+
+	control, err := parentDialer(parent).DialContext(ctx, "tcp", p.URL.Host)
+	if err != nil {
+		return nil, fmt.Errorf("prox: cannot connect to socks5 proxy: %v", err)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		control.SetDeadline(deadline)
+	}
+
+	relay, err := socks5Associate(control, p.URL)
+	if err != nil {
+		control.Close()
+		return nil, err
+	}
+
+	control.SetDeadline(time.Time{})
+
+	// Proxies often reply with an unspecified address, meaning the relay is on the proxy's own address. Through a
+	// parent proxy, the control connection's remote address is the parent's, so the proxy's host is looked up.
+	if relay.IP == nil || relay.IP.IsUnspecified() {
+		addr := control.RemoteAddr().String()
+		if parent != nil {
+			addr, err = resolveTarget(ctx, p.URL.Host)
+			if err != nil {
+				control.Close()
+				return nil, err
+			}
+		}
+
+		host, _, _ := net.SplitHostPort(addr)
+		relay.IP = net.ParseIP(host)
+	}
+
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		control.Close()
+		return nil, fmt.Errorf("prox: cannot listen for udp: %v", err)
+	}
+
+	pc := &socks5PacketConn{
+		conn:      conn,
+		control:   control,
+		relay:     relay,
+		remoteDNS: p.URL.Scheme == "socks5h",
+		buf:       make([]byte, socks5MaxDatagram),
+	}
+	go pc.watch()
+
+	return pc, nil
+}
+
+// CheckUDP checks that the proxy grants a SOCKS5 UDP ASSOCIATE request within the timeout given, and if it does,
+// adds CapabilityUDP to the proxy's capabilities. It is always false for proxies which aren't SOCKS5 proxies.
+// A proxy which grants the request could still fail to relay datagrams, so this is only as good as the proxy's
+// word.
+func (p *Proxy) CheckUDP(timeout time.Duration) bool {
+	if p.URL.Scheme != "socks5" && p.URL.Scheme != "socks5h" {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	conn, err := p.ListenPacket(ctx)
+	if err != nil {
+		logger.Debugf("prox: %v doesn't support udp: %v", p, err)
+		return false
+	}
+	conn.Close()
+
+	p.Capabilities |= providers.CapabilityUDP
+	return true
+}
+
+// socks5Associate authenticates over the connection to the SOCKS5 proxy at u and sends a UDP ASSOCIATE request,
+// returning the address of the proxy's relay.
+func socks5Associate(conn net.Conn, u *url.URL) (*net.UDPAddr, error) {
+	methods := []byte{socks5AuthNone}
+	if u.User != nil {
+		methods = append(methods, socks5AuthPassword)
+	}
+
+	greeting := append([]byte{socks5Version, byte(len(methods))}, methods...)
+	if _, err := conn.Write(greeting); err != nil {
+		return nil, fmt.Errorf("prox: cannot send socks5 greeting: %v", err)
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return nil, fmt.Errorf("prox: cannot read socks5 greeting reply: %v", err)
+	}
+
+	if reply[0] != socks5Version {
+		return nil, fmt.Errorf("prox: not a socks5 proxy")
+	}
+
+	switch reply[1] {
+	case socks5AuthNone:
+	case socks5AuthPassword:
+		if u.User == nil {
+			return nil, fmt.Errorf("prox: socks5 proxy requires credentials")
+		}
+
+		if err := socks5Authenticate(conn, u.User); err != nil {
+			return nil, err
+		}
+	case socks5AuthNoAcceptable:
+		return nil, fmt.Errorf("prox: socks5 proxy accepted none of the authentication methods offered")
+	default:
+		return nil, fmt.Errorf("prox: socks5 proxy chose unsupported authentication method %#x", reply[1])
+	}
+
+	// The client's address is sent as 0.0.0.0:0, since it isn't known until the first datagram is sent.
+	request := []byte{socks5Version, socks5CommandUDP, 0, socks5AddrIPv4, 0, 0, 0, 0, 0, 0}
+	if _, err := conn.Write(request); err != nil {
+		return nil, fmt.Errorf("prox: cannot send socks5 udp associate request: %v", err)
+	}
+
+	header := make([]byte, 3)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, fmt.Errorf("prox: cannot read socks5 udp associate reply: %v", err)
+	}
+
+	if header[1] != socks5Succeeded {
+		reason, ok := socks5Replies[header[1]]
+		if !ok {
+			reason = fmt.Sprintf("code %#x", header[1])
+		}
+
+		return nil, fmt.Errorf("prox: socks5 proxy refused udp associate request: %v", reason)
+	}
+
+	host, port, err := readSOCKS5Addr(conn)
+	if err != nil {
+		return nil, fmt.Errorf("prox: cannot read socks5 relay address: %v", err)
+	}
+
+	return &net.UDPAddr{IP: net.ParseIP(host), Port: port}, nil
+}
+
+// socks5Authenticate sends the credentials given using username/password authentication, see RFC 1929.
+func socks5Authenticate(conn net.Conn, user *url.Userinfo) error {
+	username := user.Username()
+	password, _ := user.Password()
+
+	if len(username) > 255 || len(password) > 255 {
+		return fmt.Errorf("prox: socks5 username or password is longer than 255 bytes")
+	}
+
+	request := []byte{socks5PasswordVersion, byte(len(username))}
+	request = append(request, username...)
+	request = append(request, byte(len(password)))
+	request = append(request, password...)
+
+	if _, err := conn.Write(request); err != nil {
+		return fmt.Errorf("prox: cannot send socks5 credentials: %v", err)
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("prox: cannot read socks5 authentication reply: %v", err)
+	}
+
+	if reply[1] != socks5Succeeded {
+		return fmt.Errorf("prox: socks5 proxy rejected the credentials")
+	}
+
+	return nil
+}
+
+// readSOCKS5Addr reads an address type, address and port, as found in SOCKS5 replies and UDP headers.
+func readSOCKS5Addr(r io.Reader) (string, int, error) {
+	atyp := make([]byte, 1)
+	if _, err := io.ReadFull(r, atyp); err != nil {
+		return "", 0, err
+	}
+
+	var host string
+
+	switch atyp[0] {
+	case socks5AddrIPv4, socks5AddrIPv6:
+		ip := make([]byte, net.IPv4len)
+		if atyp[0] == socks5AddrIPv6 {
+			ip = make([]byte, net.IPv6len)
+		}
+
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", 0, err
+		}
+
+		host = net.IP(ip).String()
+	case socks5AddrDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(r, length); err != nil {
+			return "", 0, err
+		}
+
+		name := make([]byte, length[0])
+		if _, err := io.ReadFull(r, name); err != nil {
+			return "", 0, err
+		}
+
+		host = string(name)
+	default:
+		return "", 0, fmt.Errorf("unknown address type %#x", atyp[0])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return "", 0, err
+	}
+
+	return host, int(binary.BigEndian.Uint16(port)), nil
+}
+
+// appendSOCKS5Addr appends the address in the form host:port to b, as found in SOCKS5 requests and UDP headers.
+func appendSOCKS5Addr(b []byte, addr string) ([]byte, error) {
+	host, rawport, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := strconv.ParseUint(rawport, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %v", rawport)
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			b = append(append(b, socks5AddrIPv4), ip4...)
+		} else {
+			b = append(append(b, socks5AddrIPv6), ip...)
+		}
+	} else {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("hostname %v is longer than 255 bytes", host)
+		}
+
+		b = append(append(b, socks5AddrDomain, byte(len(host))), host...)
+	}
+
+	return append(b, byte(port>>8), byte(port)), nil
+}
+
+// Addr is the address of a datagram sent through a proxy. Datagrams from hosts the proxy sends as a hostname
+// rather than an IP address have an Addr instead of a *net.UDPAddr.
+type Addr struct {
+	Host string
+	Port int
+}
+
+// Network returns "udp".
+func (a *Addr) Network() string {
+	return "udp"
+}
+
+// String returns the address in the form host:port.
+func (a *Addr) String() string {
+	return net.JoinHostPort(a.Host, strconv.Itoa(a.Port))
+}
+
+// socks5PacketConn is a net.PacketConn whose datagrams are relayed by a SOCKS5 proxy. Each datagram is prefixed
+// with a header giving its destination or source, see RFC 1928 section 7.
+type socks5PacketConn struct {
+	conn    *net.UDPConn
+	control net.Conn
+	relay   *net.UDPAddr
+
+	// remoteDNS is true if hostnames are sent to the proxy to resolve, rather than resolved before sending.
+	remoteDNS bool
+
+	// buf holds datagrams from the proxy while their header is removed. It is reused between reads, so readMu
+	// must be held to use it.
+	readMu sync.Mutex
+	buf    []byte
+}
+
+// watch closes the connection when the proxy closes the TCP connection the association was made over, since the
+// association ends with it.
+func (c *socks5PacketConn) watch() {
+	io.Copy(ioutil.Discard, c.control)
+	c.Close()
+}
+
+// ReadFrom reads a datagram relayed by the proxy, returning the address it came from.
+func (c *socks5PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	buf := c.buf
+
+	for {
+		n, from, err := c.conn.ReadFromUDP(buf)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		// Ignore anything which doesn't come from the relay, and fragments, which aren't supported.
+		if !from.IP.Equal(c.relay.IP) || from.Port != c.relay.Port || n < 4 || buf[2] != 0 {
+			continue
+		}
+
+		r := bytes.NewReader(buf[3:n])
+		host, port, err := readSOCKS5Addr(r)
+		if err != nil {
+			continue
+		}
+
+		var addr net.Addr = &Addr{Host: host, Port: port}
+		if ip := net.ParseIP(host); ip != nil {
+			addr = &net.UDPAddr{IP: ip, Port: port}
+		}
+
+		return copy(b, buf[n-r.Len():n]), addr, nil
+	}
+}
+
+// WriteTo sends a datagram to addr through the proxy.
+func (c *socks5PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	target := addr.String()
+	if !c.remoteDNS {
+		var err error
+
+		target, err = resolveTarget(context.Background(), target)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	datagram, err := appendSOCKS5Addr([]byte{0, 0, 0}, target)
+	if err != nil {
+		return 0, fmt.Errorf("prox: invalid udp destination %v: %v", addr, err)
+	}
+
+	if _, err := c.conn.WriteToUDP(append(datagram, b...), c.relay); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// Close ends the association and closes the connection.
+func (c *socks5PacketConn) Close() error {
+	c.control.Close()
+	return c.conn.Close()
+}
+
+// LocalAddr returns the local address datagrams are sent to the proxy from.
+func (c *socks5PacketConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// SetDeadline sets the read and write deadlines of the connection.
+func (c *socks5PacketConn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for ReadFrom calls.
+func (c *socks5PacketConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline for WriteTo calls.
+func (c *socks5PacketConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
@@ -0,0 +1,139 @@
+package prox_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ollybritton/prox"
+	"github.com/ollybritton/prox/providers"
+	"github.com/stretchr/testify/assert"
+)
+
+// startUDPEcho starts a UDP server which sends every datagram back to where it came from, and returns its address.
+func startUDPEcho(t *testing.T) *net.UDPAddr {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 65535)
+
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+
+			conn.WriteToUDP(buf[:n], from)
+		}
+	}()
+
+	return conn.LocalAddr().(*net.UDPAddr)
+}
+
+// roundTrip sends a datagram to addr and returns the reply and where it came from.
+func roundTrip(t *testing.T, conn net.PacketConn, addr net.Addr, message string) (string, net.Addr) {
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := conn.WriteTo([]byte(message), addr); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 1024)
+	n, from, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(buf[:n]), from
+}
+
+// TestListenPacket tests that datagrams are relayed through a SOCKS5 proxy in both directions, and that only
+// socks5h proxies are sent hostnames to resolve.
+func TestListenPacket(t *testing.T) {
+	echo := startUDPEcho(t)
+	addr := startSOCKS5UDPProxy(t, "user", "pass")
+
+	p := proxyFor(t, "socks5://user:pass@"+addr)
+
+	conn, err := p.ListenPacket(context.Background())
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	_, ok := conn.(io.Reader)
+	assert.False(t, ok, "reads which bypass the proxy's headers shouldn't be possible")
+
+	reply, from := roundTrip(t, conn, echo, "hello")
+	assert.Equal(t, "hello", reply)
+	assert.Equal(t, echo.String(), from.String())
+
+	reply, _ = roundTrip(t, conn, echo, "and again")
+	assert.Equal(t, "and again", reply, "the read buffer should be reused without mixing up datagrams")
+
+	_, err = conn.WriteTo([]byte("resolved locally"), &prox.Addr{Host: remoteOnlyHost, Port: echo.Port})
+	if assert.NotNil(t, err, "socks5 proxies should resolve hostnames locally") {
+		assert.Contains(t, err.Error(), "cannot find an address")
+	}
+
+	p = proxyFor(t, "socks5h://user:pass@"+addr)
+
+	conn, err = p.ListenPacket(context.Background())
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	reply, _ = roundTrip(t, conn, &prox.Addr{Host: remoteOnlyHost, Port: echo.Port}, "resolved by the proxy")
+	assert.Equal(t, "resolved by the proxy", reply)
+}
+
+// TestListenPacketErrors tests that proxies which can't relay UDP return an error.
+func TestListenPacketErrors(t *testing.T) {
+	ctx := context.Background()
+
+	p := proxyFor(t, "socks5://"+startSOCKS5Proxy(t, "", ""))
+	_, err := p.ListenPacket(ctx)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "command not supported")
+	}
+
+	p = proxyFor(t, "socks5://user:wrong@"+startSOCKS5UDPProxy(t, "user", "pass"))
+	_, err = p.ListenPacket(ctx)
+	assert.NotNil(t, err, "the proxy should reject the wrong password")
+
+	p = proxyFor(t, "http://1.1.1.1:80")
+	_, err = p.ListenPacket(ctx)
+	assert.NotNil(t, err)
+}
+
+// TestCheckUDP tests that the UDP capability is only found on proxies which support UDP ASSOCIATE.
+func TestCheckUDP(t *testing.T) {
+	udp := proxyFor(t, "socks5h://"+startSOCKS5UDPProxy(t, "", ""))
+	tcp := proxyFor(t, "socks5://"+startSOCKS5Proxy(t, "", ""))
+
+	assert.True(t, udp.CheckUDP(5*time.Second))
+	assert.True(t, udp.Can(providers.CapabilityUDP))
+
+	assert.False(t, tcp.CheckUDP(5*time.Second))
+	assert.False(t, tcp.Can(providers.CapabilityUDP))
+
+	filtered := prox.ApplyFilters(
+		[]providers.Proxy{
+			{URL: udp.URL, Provider: "Test", Country: "GB"},
+			{URL: tcp.URL, Provider: "Test", Country: "GB"},
+		},
+		[]prox.Filter{prox.FilterCheckCapabilities(providers.CapabilityUDP, 5*time.Second)},
+	)
+
+	if assert.Len(t, filtered, 1) {
+		assert.Equal(t, udp.URL.String(), filtered[0].URL.String())
+		assert.True(t, filtered[0].Can(providers.CapabilityUDP))
+	}
+}